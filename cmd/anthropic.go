@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Version header required by Anthropic's API
+const anthropicVersion = "2023-06-01"
+
+// useAnthropic reports whether api_format selects Anthropic's Messages API
+// instead of OpenAI chat completions
+func useAnthropic() bool {
+	return strings.EqualFold(viper.GetString("api_format"), "anthropic")
+}
+
+// setAuthHeaders adds the API key in the form the configured API expects
+func setAuthHeaders(req *http.Request) {
+	if useAnthropic() {
+		req.Header.Set("x-api-key", viper.GetString("api_key"))
+		req.Header.Set("anthropic-version", anthropicVersion)
+		return
+	}
+	req.Header.Add("Authorization", "Bearer "+viper.GetString("api_key"))
+}
+
+// Anthropic Messages API types
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Stream      bool               `json:"stream,omitempty"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	TopP        *float64           `json:"top_p,omitempty"`
+	Thinking    *anthropicThinking `json:"thinking,omitempty"`
+	Metadata    *anthropicMetadata `json:"metadata,omitempty"`
+}
+
+// anthropicMetadata carries the end user's ID, Anthropic's form of user
+type anthropicMetadata struct {
+	UserID string `json:"user_id"`
+}
+
+// anthropicThinking turns on extended thinking with a token budget
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type anthropicMessage struct {
+	Role    string         `json:"role"`
+	Content string         `json:"content"`
+	Images  []messageImage `json:"-"` // Sent as image blocks before the text
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Usage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+// toAnthropicRequest converts a chat completion request. System messages
+// become the top-level system prompt, and consecutive messages from the same
+// role are joined since the API wants them to alternate. Penalties have no
+// Anthropic equivalent and are dropped. A reasoning effort becomes a thinking
+// budget, which the API only accepts without sampling settings and with room
+// left in max_tokens for the answer.
+func toAnthropicRequest(r ChatRequest) anthropicRequest {
+	out := anthropicRequest{
+		Model:       r.Model,
+		MaxTokens:   r.MaxTokens,
+		Stream:      r.Stream,
+		Temperature: r.Temperature,
+		TopP:        r.TopP,
+	}
+	if r.User != "" {
+		out.Metadata = &anthropicMetadata{UserID: r.User}
+	}
+	if budget := thinkingBudget(r.ReasoningEffort); budget > 0 {
+		out.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+		out.Temperature, out.TopP = nil, nil
+		if out.MaxTokens <= budget {
+			out.MaxTokens += budget
+		}
+	}
+	var system []string
+	for _, m := range r.Messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		if n := len(out.Messages); n > 0 && out.Messages[n-1].Role == m.Role {
+			out.Messages[n-1].Content += "\n\n" + m.Content
+			out.Messages[n-1].Images = append(out.Messages[n-1].Images, m.images...)
+			continue
+		}
+		out.Messages = append(out.Messages, anthropicMessage{Role: m.Role, Content: m.Content, Images: m.images})
+	}
+	out.System = strings.Join(system, "\n\n")
+	return out
+}
+
+// newChatRequest builds the HTTP request for a chat call in the configured
+// API format
+func newChatRequest(ctx context.Context, reqBody ChatRequest) (*http.Request, error) {
+	path := "/chat/completions"
+	var payload interface{} = reqBody
+	if useAnthropic() {
+		path = "/messages"
+		payload = toAnthropicRequest(reqBody)
+	} else if hasImages(reqBody.Messages) {
+		payload = toVisionRequest(reqBody)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", viper.GetString("api_endpoint")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	setAuthHeaders(req)
+	return req, nil
+}
+
+// convertAnthropicResponse rewrites a non-streaming Messages API response
+// body as a chat completion response, so callers can decode either the same
+// way
+func convertAnthropicResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+	var ar anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
+		return err
+	}
+
+	var text strings.Builder
+	for _, block := range ar.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	converted := ChatResponse{
+		Choices: []Choice{{Message: Message{Content: text.String()}}},
+		Usage: &TokenUsage{
+			PromptTokens:     ar.Usage.InputTokens,
+			CompletionTokens: ar.Usage.OutputTokens,
+			TotalTokens:      ar.Usage.InputTokens + ar.Usage.OutputTokens,
+		},
+	}
+	data, err := json.Marshal(converted)
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Delay between broadcast sends, keeping well under Telegram's ~30 messages
+// per second global limit
+const broadcastInterval = 50 * time.Millisecond
+
+// knownChatIDs lists every chat that has a state file
+func knownChatIDs() ([]int64, error) {
+	paths, err := filepath.Glob("./data/store/user_*.json")
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(paths))
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "user_"), ".json")
+		id, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			logger.Warn("skipping unrecognized state file", slog.String("path", path))
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// handleBroadcast implements /broadcast <message> for admins
+func handleBroadcast(c telebot.Context) error {
+	if userRole(c.Sender().ID) != roleAdmin {
+		return refuseUser(c)
+	}
+	text := strings.TrimSpace(c.Message().Payload)
+	if text == "" {
+		return c.Send("Usage: /broadcast <message>")
+	}
+
+	ids, err := knownChatIDs()
+	if err != nil {
+		return c.Send("Failed to list chats: " + err.Error())
+	}
+	c.Send(fmt.Sprintf("Broadcasting to %d chats...", len(ids)))
+	logger.Warn("broadcast started", slog.Int64("user_id", c.Sender().ID), slog.Int("chats", len(ids)))
+
+	sent, failed := 0, 0
+	for i, id := range ids {
+		if i > 0 {
+			time.Sleep(broadcastInterval)
+		}
+		if _, err := bot.Send(&telebot.Chat{ID: id}, text); err != nil {
+			logger.Warn("broadcast send failed", slog.Int64("chat_id", id), slog.Any("error", err))
+			failed++
+			continue
+		}
+		sent++
+	}
+
+	logger.Info("broadcast finished", slog.Int("sent", sent), slog.Int("failed", failed))
+	return c.Send(fmt.Sprintf("Broadcast finished: %d sent, %d failed.", sent, failed))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+// callbackHandlers maps a button's Unique to the handler for taps on it.
+// Buttons made with markup.Data carry "\f<unique>|<data>" as callback data.
+var callbackHandlers = make(map[string]telebot.HandlerFunc)
+
+// handleButton registers the handler for taps on buttons with btn's Unique.
+// The handler sees the button's data in c.Callback().Data. A callback it
+// doesn't answer with c.Respond is answered afterwards, so the button's
+// spinner always stops.
+func handleButton(btn telebot.Btn, handler telebot.HandlerFunc) {
+	if _, ok := callbackHandlers[btn.Unique]; ok {
+		panic("duplicate callback handler for " + btn.Unique)
+	}
+	callbackHandlers[btn.Unique] = handler
+}
+
+// respondTracker notes whether a callback handler answered the callback
+type respondTracker struct {
+	telebot.Context
+	responded bool
+}
+
+func (t *respondTracker) Respond(resp ...*telebot.CallbackResponse) error {
+	t.responded = true
+	return t.Context.Respond(resp...)
+}
+
+// dispatchCallback routes a callback query to its button's handler by the
+// Unique prefix of its data. Taps on buttons nothing handles, e.g. from an
+// older version of the bot, are answered with a notice.
+func dispatchCallback(c telebot.Context) error {
+	cb := c.Callback()
+	unique, data, _ := strings.Cut(strings.TrimPrefix(cb.Data, "\f"), "|")
+	handler, ok := callbackHandlers[unique]
+	if !ok {
+		logger.Debug("callback for unknown button", slog.Int64("user_id", c.Sender().ID), slog.String("unique", unique))
+		return c.Respond(&telebot.CallbackResponse{Text: "This button no longer works."})
+	}
+	cb.Unique, cb.Data = unique, data
+
+	tracker := &respondTracker{Context: c}
+	err := handler(tracker)
+	if !tracker.responded {
+		if respondErr := c.Respond(); respondErr != nil {
+			logger.Debug("failed to answer callback", slog.String("unique", unique), slog.Any("error", respondErr))
+		}
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// Chain is a named sequence of prompts from the chains config. Each step's
+// output becomes the next step's input.
+type Chain struct {
+	Name             string      `mapstructure:"name"`
+	Description      string      `mapstructure:"description"`
+	ShowIntermediate bool        `mapstructure:"show_intermediate"` // Send every step's output, not just the last
+	Steps            []ChainStep `mapstructure:"steps"`
+}
+
+// ChainStep is one prompt in a chain. {{input}} in Prompt is replaced with the
+// previous step's output (or the user's text for the first step); if Prompt
+// is empty the input is sent as is.
+type ChainStep struct {
+	Model        string `mapstructure:"model"`         // Defaults to the user's current model
+	SystemPrompt string `mapstructure:"system_prompt"` // Defaults to no system prompt
+	Prompt       string `mapstructure:"prompt"`
+}
+
+// loadChains reads the chains config
+func loadChains() ([]Chain, error) {
+	var chains []Chain
+	if err := viper.UnmarshalKey("chains", &chains); err != nil {
+		return nil, err
+	}
+	return chains, nil
+}
+
+func findChain(name string) (*Chain, error) {
+	chains, err := loadChains()
+	if err != nil {
+		return nil, err
+	}
+	for i := range chains {
+		if strings.EqualFold(chains[i].Name, name) {
+			return &chains[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func chainList() (string, error) {
+	chains, err := loadChains()
+	if err != nil {
+		return "", err
+	}
+	if len(chains) == 0 {
+		return "No chains configured.", nil
+	}
+	msg := "Available chains:\n\n"
+	for _, ch := range chains {
+		msg += "- " + ch.Name + fmt.Sprintf(" (%d steps)", len(ch.Steps))
+		if ch.Description != "" {
+			msg += ": " + ch.Description
+		}
+		msg += "\n"
+	}
+	return msg + "\nUsage: /chain <name> <input>", nil
+}
+
+// runChain runs each step in order, reporting progress on the chat. It stops
+// between steps (or mid-request) when the context is cancelled.
+func runChain(ctx context.Context, c telebot.Context, chain *Chain, model, input string) error {
+	progress, _ := bot.Send(c.Chat(), fmt.Sprintf("Running chain %s...", chain.Name))
+
+	output := input
+	for i, step := range chain.Steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if progress != nil {
+			bot.Edit(progress, fmt.Sprintf("Running chain %s: step %d/%d...", chain.Name, i+1, len(chain.Steps)))
+		}
+		bot.Notify(c.Chat(), telebot.Typing)
+
+		prompt := output
+		if step.Prompt != "" {
+			prompt = strings.ReplaceAll(step.Prompt, "{{input}}", output)
+		}
+		stepModel := model
+		if step.Model != "" {
+			stepModel = step.Model
+		}
+
+		messages := []ChatMessage{}
+		if systemPrompt := withSystemPrefix(step.SystemPrompt); systemPrompt != "" {
+			messages = append(messages, ChatMessage{Role: "system", Content: systemPrompt})
+		}
+		messages = append(messages, ChatMessage{Role: "user", Content: prompt})
+
+		result, err := complete(ctx, stepModel, messages)
+		if err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+		if result == "" {
+			return fmt.Errorf("step %d: no response received", i+1)
+		}
+		output = result
+
+		if chain.ShowIntermediate && i < len(chain.Steps)-1 {
+			sendResponse(ctx, c.Chat(), fmt.Sprintf("Step %d/%d (%s):\n\n%s", i+1, len(chain.Steps), stepModel, output))
+		}
+	}
+
+	if progress != nil {
+		bot.Edit(progress, fmt.Sprintf("Chain %s finished (%d steps).", chain.Name, len(chain.Steps)))
+	}
+	sendResponse(ctx, c.Chat(), output)
+	return nil
+}
+
+// handleChain implements /chain and /chain <name> <input>
+func handleChain(c telebot.Context) error {
+	args := strings.Fields(strings.TrimPrefix(c.Message().Text, "/chain"))
+	if len(args) == 0 {
+		list, err := chainList()
+		if err != nil {
+			return c.Send("Invalid chains config: " + err.Error())
+		}
+		return c.Send(list)
+	}
+
+	chain, err := findChain(args[0])
+	if err != nil {
+		return c.Send("Invalid chains config: " + err.Error())
+	}
+	if chain == nil {
+		return c.Send("Chain " + args[0] + " not found. Use /chain to list chains.")
+	}
+	if len(chain.Steps) == 0 {
+		return c.Send("Chain " + chain.Name + " has no steps.")
+	}
+	if len(args) < 2 {
+		return c.Send("Usage: /chain " + chain.Name + " <input>")
+	}
+
+	// Keep the input's original spacing and newlines
+	text := strings.TrimSpace(strings.TrimPrefix(c.Message().Text, "/chain"))
+	input := strings.TrimSpace(text[len(args[0]):])
+
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+
+	ctx, done := beginRequest(chatID)
+	defer done()
+	if err := runChain(ctx, c, chain, effectiveModel(state), input); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return c.Send("Chain cancelled.")
+		}
+		return c.Send("Chain failed: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gopkg.in/telebot.v3"
+)
+
+// compareAnswer is one model's side of a /compare
+type compareAnswer struct {
+	model   string
+	reply   string
+	scratch UserState // Collects the request's token usage
+	err     error
+}
+
+// handleCompare implements /compare <modelA> <modelB> <prompt>: both models
+// answer the prompt at once, with the chat's system prompt and settings but
+// no history, and the conversation is left as it was
+func handleCompare(c telebot.Context) error {
+	args := strings.Fields(c.Message().Payload)
+	if len(args) < 3 {
+		return c.Send("Usage: /compare <modelA> <modelB> <prompt>")
+	}
+	models := args[:2]
+	prompt := strings.Join(args[2:], " ")
+
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+	for _, model := range models {
+		if refusal := modelRefusal(c.Sender().ID, model); refusal != "" {
+			return c.Send(refusal)
+		}
+	}
+
+	opts := chatOptions{Username: senderName(c.Sender().FirstName, c.Sender().Username), Stateless: true, UserID: c.Sender().ID}
+	bot.Notify(c.Chat(), telebot.Typing)
+	ctx, done := beginRequest(chatID)
+	defer done()
+
+	answers := make([]compareAnswer, len(models))
+	var wg sync.WaitGroup
+	for i, model := range models {
+		opts.Model = model
+		reqBody := buildChatRequest(state, nil, prompt, opts)
+		reqBody.Stream, reqBody.StreamOptions = false, nil
+
+		wg.Add(1)
+		go func(answer *compareAnswer, reqBody ChatRequest) {
+			defer wg.Done()
+			release, err := acquireRequestSlot(ctx)
+			if err != nil {
+				answer.err = err
+				return
+			}
+			defer release()
+			ctx, cancel := withRequestTimeout(ctx, false)
+			defer cancel()
+			// Usage goes to a scratch state, as the two requests run at once
+			answer.reply, answer.model, answer.err = requestWithFallback(ctx, &answer.scratch, reqBody, nil)
+		}(&answers[i], reqBody)
+	}
+	wg.Wait()
+
+	// Count the tokens spent, but otherwise leave the chat's state alone
+	for i, answer := range answers {
+		state.SessionUsage.add(answer.scratch.SessionUsage)
+		state.LifetimeUsage.add(answer.scratch.LifetimeUsage)
+
+		label := models[i]
+		if answer.model != "" && answer.model != models[i] {
+			label = fmt.Sprintf("%s (unavailable, answered by %s)", models[i], answer.model)
+		}
+		switch {
+		case errors.Is(answer.err, context.Canceled):
+			c.Send(label + ": request cancelled.")
+		case answer.err != nil:
+			c.Send(label + ": error: " + answer.err.Error())
+		case answer.reply == "":
+			c.Send(label + ": no response received.")
+		default:
+			sendResponse(ctx, c.Chat(), "— "+label+" —\n\n"+answer.reply)
+		}
+	}
+	saveUserState(chatID, state)
+	return nil
+}
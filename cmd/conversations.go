@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Most conversations a chat can keep with /save
+const maxSavedConversations = 20
+
+// Names conversations can be saved under
+var conversationNamePattern = regexp.MustCompile(`^[\w-]{1,32}$`)
+
+// SavedConversation is a snapshot of a chat's conversation taken with /save
+type SavedConversation struct {
+	History []ChatMessage `json:"history"`
+	Summary string        `json:"summary,omitempty"`
+	Title   string        `json:"title,omitempty"`
+	SavedAt time.Time     `json:"saved_at"`
+}
+
+// handleSave implements /save <name>: the current conversation is stored
+// under name, replacing an earlier one with the same name
+func handleSave(c telebot.Context) error {
+	name := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+	if name == "" {
+		return c.Send("Usage: /save <name> - keep a copy of this conversation to /load later")
+	}
+	if !conversationNamePattern.MatchString(name) {
+		return c.Send("Conversation names are one word of up to 32 letters, digits, - or _.")
+	}
+
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+	if state.Private {
+		return c.Send("Saved conversations are written to disk, so /save is off in private mode.")
+	}
+	if len(state.History) == 0 && state.Summary == "" {
+		return c.Send("Nothing to save yet.")
+	}
+	_, replacing := state.Conversations[name]
+	if !replacing && len(state.Conversations) >= maxSavedConversations {
+		return c.Send(fmt.Sprintf("You already have %d saved conversations. Save over an old name to replace it.", maxSavedConversations))
+	}
+
+	if state.Conversations == nil {
+		state.Conversations = make(map[string]SavedConversation)
+	}
+	state.Conversations[name] = SavedConversation{
+		History: append([]ChatMessage(nil), state.History...),
+		Summary: state.Summary,
+		Title:   state.Title,
+		SavedAt: time.Now().UTC(),
+	}
+	saveUserState(chatID, state)
+	if replacing {
+		return c.Send(fmt.Sprintf("Replaced saved conversation %q (%d messages).", name, len(state.History)))
+	}
+	return c.Send(fmt.Sprintf("Saved this conversation as %q (%d messages). /load %s to come back to it.", name, len(state.History), name))
+}
+
+// handleLoad implements /load <name>: the current conversation is replaced
+// by a copy of a saved one
+func handleLoad(c telebot.Context) error {
+	name := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+	if name == "" {
+		return c.Send("Usage: /load <name> - switch to a saved conversation (see /conversations)")
+	}
+
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+	saved, ok := state.Conversations[name]
+	if !ok {
+		return c.Send(fmt.Sprintf("No conversation saved as %q. /conversations lists them.", name))
+	}
+
+	state.History = append([]ChatMessage(nil), saved.History...)
+	state.Summary = saved.Summary
+	state.Title = saved.Title
+	state.ReplyIndex = nil
+	state.LastMessageID = 0
+	trimHistory(state)
+	saveUserState(chatID, state)
+	return c.Send(fmt.Sprintf("Loaded conversation %q (%d messages).", name, len(state.History)))
+}
+
+// handleConversations implements /conversations
+func handleConversations(c telebot.Context) error {
+	state := loadUserState(c.Chat().ID)
+	cacheState(c.Chat().ID, state)
+	if len(state.Conversations) == 0 {
+		return c.Send("No saved conversations. /save <name> keeps a copy of the current one.")
+	}
+
+	names := make([]string, 0, len(state.Conversations))
+	for name := range state.Conversations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("Saved conversations:\n")
+	for _, name := range names {
+		saved := state.Conversations[name]
+		fmt.Fprintf(&b, "\n%s - ", name)
+		if saved.Title != "" {
+			b.WriteString(saved.Title + ", ")
+		}
+		fmt.Fprintf(&b, "%d messages, saved %s", len(saved.History), saved.SavedAt.Format("2006-01-02 15:04 MST"))
+	}
+	b.WriteString("\n\n/load <name> switches to one.")
+	return c.Send(b.String())
+}
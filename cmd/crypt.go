@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// Files encrypted with state_encryption_key start with this marker, followed
+// by the GCM nonce and the sealed data
+var encryptedMagic = []byte("tlbenc1:")
+
+// stateCipher returns the AES-256-GCM cipher for state files, keyed by the
+// SHA-256 of state_encryption_key, or nil if encryption is off
+func stateCipher() (cipher.AEAD, error) {
+	secret := viper.GetString("state_encryption_key")
+	if secret == "" {
+		return nil, nil
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealData encrypts data for writing to disk when state_encryption_key is
+// set, and returns it unchanged otherwise
+func sealData(data []byte) ([]byte, error) {
+	gcm, err := stateCipher()
+	if gcm == nil || err != nil {
+		return data, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, encryptedMagic...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, nil), nil
+}
+
+// openData decrypts data read from disk. Plaintext files, written before
+// encryption was turned on, are returned as they are with encrypted false.
+func openData(data []byte) (plain []byte, encrypted bool, err error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, false, nil
+	}
+	gcm, err := stateCipher()
+	if err != nil {
+		return nil, true, err
+	}
+	if gcm == nil {
+		return nil, true, errors.New("file is encrypted but state_encryption_key is not set")
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < gcm.NonceSize() {
+		return nil, true, errors.New("encrypted file is truncated")
+	}
+	plain, err = gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, true, errors.New("decryption failed, state_encryption_key may have changed")
+	}
+	return plain, true, nil
+}
+
+// checkStateFiles makes sure every encrypted file in data/store can be
+// decrypted with the configured key, so a changed or missing key stops the
+// bot instead of starting every chat from scratch
+func checkStateFiles() error {
+	paths, err := filepath.Glob("./data/store/*.json")
+	if err != nil {
+		return err
+	}
+	archived, _ := filepath.Glob("./data/store/archive/*.json")
+	for _, path := range append(paths, archived...) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if _, _, err := openData(data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Headers that carry the API key and are redacted in debug logs
+var secretHeaders = []string{"Authorization", "X-Api-Key"}
+
+// debugLogging reports whether full API payloads should be logged
+func debugLogging() bool {
+	return viper.GetBool("debug_logging")
+}
+
+// redactedHeaders returns the request headers with credentials masked
+func redactedHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		out[name] = strings.Join(values, ", ")
+	}
+	for _, name := range secretHeaders {
+		if _, ok := out[name]; ok {
+			out[name] = "[REDACTED]"
+		}
+	}
+	return out
+}
+
+// logAPIRequest logs an outgoing request's headers and body at debug level
+func logAPIRequest(req *http.Request) {
+	var body []byte
+	if req.GetBody != nil {
+		if r, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(r)
+			r.Close()
+		}
+	}
+	requestLogger(req.Context()).Debug("API request",
+		slog.String("method", req.Method),
+		slog.String("url", req.URL.String()),
+		slog.Any("headers", redactedHeaders(req.Header)),
+		slog.String("body", string(body)))
+}
+
+// loggedBody copies a response body as it's read and logs it in full once
+// closed, so streamed responses are logged too
+type loggedBody struct {
+	io.ReadCloser
+	status int
+	buf    bytes.Buffer
+	log    *slog.Logger
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	b.log.Debug("API response", slog.Int("status", b.status), slog.String("body", b.buf.String()))
+	return b.ReadCloser.Close()
+}
+
+// logAPIResponse arranges for a response body to be logged once read
+func logAPIResponse(resp *http.Response) {
+	resp.Body = &loggedBody{ReadCloser: resp.Body, status: resp.StatusCode, log: requestLogger(resp.Request.Context())}
+}
+
+// fingerprint identifies a secret in logs by its first and last 4 characters,
+// masking the rest. Short secrets are masked entirely.
+func fingerprint(secret string) string {
+	if len(secret) < 16 {
+		return "****"
+	}
+	return secret[:4] + "****" + secret[len(secret)-4:]
+}
+
+// redactSecrets replaces the bot token and API key in text, such as an error
+// that quotes a Telegram URL, with their fingerprints
+func redactSecrets(text string) string {
+	for _, key := range []string{"api_token", "api_key"} {
+		if secret := viper.GetString(key); secret != "" {
+			text = strings.ReplaceAll(text, secret, fingerprint(secret))
+		}
+	}
+	return text
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// Defaults for document_max_bytes and document_max_pages
+const (
+	defaultDocumentMaxBytes = 10 * 1024 * 1024
+	defaultDocumentMaxPages = 100
+)
+
+// Characters of document text sent to the model at once. Longer documents
+// are summarized part by part first.
+const documentChunkChars = 12000
+
+// Prompt used when a document is sent without a caption
+const defaultDocumentPrompt = "Summarize this document."
+
+// Defaults for attachment_max_bytes and attachment_turns
+const (
+	defaultAttachmentMaxBytes = 32 * 1024
+	defaultAttachmentTurns    = 5
+)
+
+// Extensions of text and code files that can be attached
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".go": true, ".py": true, ".js": true, ".ts": true,
+	".java": true, ".c": true, ".h": true, ".cpp": true, ".cs": true, ".rs": true,
+	".rb": true, ".php": true, ".sh": true, ".yaml": true, ".yml": true, ".toml": true,
+	".html": true, ".css": true, ".sql": true, ".csv": true, ".xml": true, ".log": true,
+}
+
+// Attachment is a small text file kept in a chat's context: it's sent with
+// each message until TurnsLeft runs out or /clearfile drops it
+type Attachment struct {
+	Name      string `json:"name"`
+	Content   string `json:"content"`
+	TurnsLeft int    `json:"turns_left"`
+}
+
+// queuedDocument is an uploaded document a queued message asks about. Only
+// the reference to the upload is saved with the queue, and a restored queue
+// reads the document again.
+type queuedDocument struct {
+	Name   string
+	Upload telebot.Document
+	Chunks []string `json:"-"` // Extracted text, split for summarizing
+}
+
+// isPDFDocument and isTextDocument report which kind of readable document an
+// upload is
+func isPDFDocument(doc *telebot.Document) bool {
+	return doc.MIME == "application/pdf" || strings.EqualFold(filepath.Ext(doc.FileName), ".pdf")
+}
+
+func isTextDocument(doc *telebot.Document) bool {
+	return strings.HasPrefix(doc.MIME, "text/") || textExtensions[strings.ToLower(filepath.Ext(doc.FileName))]
+}
+
+// extractPDFText returns the text of a PDF, refusing ones with more than
+// maxPages pages
+func extractPDFText(data []byte, maxPages int) (text string, err error) {
+	// The parser can panic on malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("could not parse PDF: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	if r.NumPage() > maxPages {
+		return "", fmt.Errorf("PDF has %d pages (max %d)", r.NumPage(), maxPages)
+	}
+
+	var b strings.Builder
+	fonts := make(map[string]*pdf.Font)
+	for i := 1; i <= r.NumPage(); i++ {
+		page := r.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		for _, name := range page.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				font := page.Font(name)
+				fonts[name] = &font
+			}
+		}
+		pageText, err := page.GetPlainText(fonts)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(pageText)
+		b.WriteString("\n")
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// chunkText splits text into pieces of at most size characters, breaking at
+// a newline where possible
+func chunkText(text string, size int) []string {
+	var chunks []string
+	for utf8.RuneCountInString(text) > size {
+		cut := len(string([]rune(text)[:size]))
+		if nl := strings.LastIndex(text[:cut], "\n"); nl > cut/2 {
+			cut = nl + 1
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	if strings.TrimSpace(text) != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// readDocument downloads an uploaded PDF or text file and extracts its text
+func readDocument(doc *telebot.Document) (string, error) {
+	maxBytes := viper.GetInt64("document_max_bytes")
+	if maxBytes <= 0 {
+		maxBytes = defaultDocumentMaxBytes
+	}
+	if doc.FileSize > maxBytes {
+		return "", fmt.Errorf("file too large (max %d KB)", maxBytes/1024)
+	}
+
+	reader, err := bot.File(&doc.File)
+	if err != nil {
+		return "", fmt.Errorf("failed to download file: %w", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return "", fmt.Errorf("file too large (max %d KB)", maxBytes/1024)
+	}
+
+	if !isPDFDocument(doc) {
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("file is not UTF-8 text")
+		}
+		return string(data), nil
+	}
+	maxPages := viper.GetInt("document_max_pages")
+	if maxPages <= 0 {
+		maxPages = defaultDocumentMaxPages
+	}
+	text, err := extractPDFText(data, maxPages)
+	if err != nil {
+		return "", err
+	}
+	if text == "" {
+		return "", fmt.Errorf("no text found in this PDF (scanned documents aren't supported)")
+	}
+	return text, nil
+}
+
+// handleDocumentQuestion reads an uploaded document and queues the caption
+// (or a request to summarize) as a question about it
+func handleDocumentQuestion(c telebot.Context, doc *telebot.Document) error {
+	text, err := readDocument(doc)
+	if err != nil {
+		return c.Send("Couldn't read " + doc.FileName + ": " + err.Error())
+	}
+	return queueDocumentQuestion(c, doc, text)
+}
+
+// queueDocumentQuestion queues the caption of an uploaded document, or a
+// request to summarize it, as a question about its text
+func queueDocumentQuestion(c telebot.Context, doc *telebot.Document, text string) error {
+	prompt := strings.TrimSpace(c.Message().Caption)
+	if prompt == "" {
+		prompt = defaultDocumentPrompt
+	}
+	return enqueueMessage(c, queuedMessage{
+		Text:      prompt,
+		MessageID: c.Message().ID,
+		Sender:    senderName(c.Sender().FirstName, c.Sender().Username),
+		Document:  &queuedDocument{Name: doc.FileName, Upload: *doc, Chunks: chunkText(text, documentChunkChars)},
+	})
+}
+
+// documentContext returns the text sent ahead of a question about a
+// document. Documents too long for one request are summarized part by part
+// first and the question is asked about the combined summaries.
+func documentContext(ctx context.Context, chatID int64, doc *queuedDocument) (string, error) {
+	if doc.Chunks == nil {
+		text, err := readDocument(&doc.Upload)
+		if err != nil {
+			return "", fmt.Errorf("couldn't read %s again: %w", doc.Name, err)
+		}
+		doc.Chunks = chunkText(text, documentChunkChars)
+	}
+	if len(doc.Chunks) == 1 {
+		return "Document " + doc.Name + ":\n\n" + doc.Chunks[0], nil
+	}
+
+	state := chatState(chatID)
+	summaries := make([]string, 0, len(doc.Chunks))
+	for i, chunk := range doc.Chunks {
+		summary, err := complete(ctx, effectiveModel(state), []ChatMessage{
+			{Role: "system", Content: "Summarize the given part of a document, keeping every important fact, name and number."},
+			{Role: "user", Content: fmt.Sprintf("Part %d of %d of %s:\n\n%s", i+1, len(doc.Chunks), doc.Name, chunk)},
+		})
+		if err != nil {
+			return "", fmt.Errorf("summarizing part %d of %d: %w", i+1, len(doc.Chunks), err)
+		}
+		summaries = append(summaries, fmt.Sprintf("Part %d:\n%s", i+1, summary))
+	}
+	return "Summaries of the parts of document " + doc.Name + ":\n\n" + strings.Join(summaries, "\n\n"), nil
+}
+
+// handleAttachment keeps an uploaded text or code file in the chat's context
+// for the next attachment_turns messages. A caption is queued as the first
+// question about it. Files over attachment_max_bytes are answered like other
+// documents instead, summarizing them part by part if needed.
+func handleAttachment(c telebot.Context, doc *telebot.Document) error {
+	text, err := readDocument(doc)
+	if err != nil {
+		return c.Send("Couldn't read " + doc.FileName + ": " + err.Error())
+	}
+	maxBytes := viper.GetInt("attachment_max_bytes")
+	if maxBytes <= 0 {
+		maxBytes = defaultAttachmentMaxBytes
+	}
+	if len(text) > maxBytes {
+		return queueDocumentQuestion(c, doc, text)
+	}
+	turns := viper.GetInt("attachment_turns")
+	if turns <= 0 {
+		turns = defaultAttachmentTurns
+	}
+
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+	state.Attachment = &Attachment{Name: doc.FileName, Content: text, TurnsLeft: turns}
+	saveUserState(chatID, state)
+
+	if caption := strings.TrimSpace(c.Message().Caption); caption != "" {
+		return enqueueMessage(c, queuedMessage{
+			Text:      caption,
+			MessageID: c.Message().ID,
+			Sender:    senderName(c.Sender().FirstName, c.Sender().Username),
+		})
+	}
+	return c.Send(fmt.Sprintf("Attached %s. It will be included with your next %d messages; /clearfile drops it sooner.", doc.FileName, turns))
+}
+
+// handleClearFile implements /clearfile
+func handleClearFile(c telebot.Context) error {
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+	if state.Attachment == nil {
+		return c.Send("No file is attached.")
+	}
+	name := state.Attachment.Name
+	state.Attachment = nil
+	saveUserState(chatID, state)
+	return c.Send("Removed " + name + " from the context.")
+}
+
+// attachmentMessage is the system message carrying an attached file
+func attachmentMessage(a *Attachment) ChatMessage {
+	return ChatMessage{Role: "system", Content: "The user attached the file " + a.Name + ":\n\n" + a.Content}
+}
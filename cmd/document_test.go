@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/telebot.v3"
+)
+
+// A queued document is saved as a reference to the upload, not its text
+func TestQueuedDocumentSavesReference(t *testing.T) {
+	body := strings.Repeat("Confidential report line.\n", 5000)
+	queued := queuedMessage{
+		Text: defaultDocumentPrompt,
+		Document: &queuedDocument{
+			Name:   "report.txt",
+			Upload: telebot.Document{File: telebot.File{FileID: "file-123"}, FileName: "report.txt"},
+			Chunks: chunkText(body, documentChunkChars),
+		},
+	}
+	data, err := json.Marshal(queued)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if strings.Contains(string(data), "Confidential") {
+		t.Errorf("saved queue entry holds the document text: %d bytes", len(data))
+	}
+
+	var restored queuedMessage
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if restored.Document == nil || restored.Document.Upload.FileID != "file-123" || restored.Document.Chunks != nil {
+		t.Errorf("restored document = %+v, want the upload reference and no text", restored.Document)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+// handleEdited resends an edited message. Only the message behind the latest
+// exchange can be edited: that exchange is dropped from the history and the
+// new text is queued in its place.
+func handleEdited(c telebot.Context) error {
+	msg := c.Message()
+	if msg.Text == "" || strings.HasPrefix(msg.Text, "/") {
+		return nil
+	}
+
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+
+	n := len(state.History)
+	if msg.ID != state.LastMessageID || n < 2 || state.History[n-2].Role != "user" {
+		return c.Send("Only your latest message can be edited and resent.")
+	}
+	state.History = state.History[:n-2]
+	state.LastMessageID = 0
+	pruneReplyIndex(state)
+	saveUserState(chatID, state)
+
+	return enqueueMessage(c, queuedMessage{
+		Text:      msg.Text,
+		MessageID: msg.ID,
+		Sender:    senderName(c.Sender().FirstName, c.Sender().Username),
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+// reasoningEfforts are the values /effort accepts, in the order they're
+// listed, with the thinking budget each is sent as to Anthropic's API
+var reasoningEfforts = []struct {
+	level  string
+	budget int
+}{
+	{"low", 1024},
+	{"medium", 4096},
+	{"high", 16384},
+}
+
+// thinkingBudget returns the Anthropic thinking budget for a reasoning
+// effort, or 0 if it isn't one
+func thinkingBudget(effort string) int {
+	for _, e := range reasoningEfforts {
+		if e.level == effort {
+			return e.budget
+		}
+	}
+	return 0
+}
+
+// effortRejected reports whether the API refused a request as invalid,
+// which for a request with reasoning_effort likely means the model or
+// backend doesn't know the field
+func effortRejected(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnprocessableEntity
+}
+
+const effortUsage = "Usage: /effort low|medium|high - how much the model reasons before answering, for models that support it\n/effort off - use the model's default"
+
+// handleEffort implements /effort
+func handleEffort(c telebot.Context) error {
+	state := loadUserState(c.Chat().ID)
+	cacheState(c.Chat().ID, state)
+	args := c.Args()
+
+	if len(args) == 0 {
+		current := state.ReasoningEffort
+		if current == "" {
+			current = "model default"
+		}
+		return c.Send("Reasoning effort: " + current + "\n\n" + effortUsage)
+	}
+	if len(args) != 1 {
+		return c.Send(effortUsage)
+	}
+
+	level := strings.ToLower(args[0])
+	switch {
+	case level == "off" || level == "default":
+		state.ReasoningEffort = ""
+		saveUserState(c.Chat().ID, state)
+		return c.Send("Reasoning effort reset to the model's default.")
+	case thinkingBudget(level) == 0:
+		return c.Send(effortUsage)
+	}
+	state.ReasoningEffort = level
+	saveUserState(c.Chat().ID, state)
+	return c.Send("Reasoning effort set to " + level + ". Models that don't support it ignore it.")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+// endUserID returns the ID sent as user when send_user_id is on: a keyed
+// hash of the Telegram user ID, stable across restarts but not reversible
+// without the key. Telegram IDs are small enough to brute-force a plain hash,
+// so it's keyed with user_id_salt, or the bot token when that isn't set.
+// Returns "" when the field shouldn't be sent.
+func endUserID(userID int64) string {
+	if !viper.GetBool("send_user_id") || userID == 0 {
+		return ""
+	}
+	key := viper.GetString("user_id_salt")
+	if key == "" {
+		key = viper.GetString("api_token")
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("telegram-user:"))
+	mac.Write([]byte(strconv.FormatInt(userID, 10)))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
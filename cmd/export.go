@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Current version of the conversation file format
+const conversationExportVersion = 1
+
+// Largest conversation file accepted for import
+const maxImportSize = 1 << 20 // 1 MB
+
+// ConversationExport is the file format written by /export and accepted when
+// a conversation file is uploaded
+type ConversationExport struct {
+	Version      int           `json:"version"`
+	ExportedAt   time.Time     `json:"exported_at"`
+	Model        string        `json:"model"`
+	SystemPrompt string        `json:"system_prompt"`
+	History      []ChatMessage `json:"history"`
+}
+
+func newConversationExport(state *UserState) *ConversationExport {
+	return &ConversationExport{
+		Version:      conversationExportVersion,
+		ExportedAt:   time.Now().UTC(),
+		Model:        state.Model,
+		SystemPrompt: state.SystemPrompt,
+		History:      state.History,
+	}
+}
+
+// marshalConversation renders the conversation in the export file format
+func marshalConversation(state *UserState) ([]byte, error) {
+	return json.MarshalIndent(newConversationExport(state), "", "  ")
+}
+
+// exportDocument renders the conversation as a JSON file ready to send
+func exportDocument(state *UserState) (*telebot.Document, error) {
+	data, err := marshalConversation(state)
+	if err != nil {
+		return nil, err
+	}
+	return &telebot.Document{
+		File:     telebot.FromReader(bytes.NewReader(data)),
+		FileName: "conversation.json",
+		MIME:     "application/json",
+	}, nil
+}
+
+// parseConversationExport decodes and validates an uploaded conversation file
+func parseConversationExport(data []byte) (*ConversationExport, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var export ConversationExport
+	if err := dec.Decode(&export); err != nil {
+		return nil, fmt.Errorf("malformed JSON: %w", err)
+	}
+	if export.Version != conversationExportVersion {
+		return nil, fmt.Errorf("unsupported version %d", export.Version)
+	}
+	for i, m := range export.History {
+		if m.Role != "user" && m.Role != "assistant" {
+			return nil, fmt.Errorf("message %d has invalid role %q", i+1, m.Role)
+		}
+	}
+	return &export, nil
+}
+
+// applyConversationExport replaces the current conversation with an imported one
+func applyConversationExport(state *UserState, export *ConversationExport) {
+	state.History = export.History
+	state.ReplyIndex = nil
+	trimHistory(state)
+	if export.SystemPrompt != "" || export.Model != "" {
+		state.ActiveSetup = ""
+	}
+	if export.SystemPrompt != "" {
+		state.SystemPrompt = export.SystemPrompt
+	}
+	if export.Model != "" {
+		state.Model = export.Model
+	}
+}
+
+// dropRefusedModel clears the model of an imported conversation if
+// user_models doesn't let the user switch to it, so the chat keeps its
+// current one. Returns a note saying so, or "" if the model can be used.
+func dropRefusedModel(userID int64, export *ConversationExport) string {
+	if export.Model == "" {
+		return ""
+	}
+	refusal := modelRefusal(userID, export.Model)
+	if refusal == "" {
+		return ""
+	}
+	export.Model = ""
+	return "\n\n" + refusal + " The current model is kept."
+}
+
+func importSummary(state *UserState) string {
+	return fmt.Sprintf("Conversation imported: %d messages.\nModel: %s", len(state.History), state.Model)
+}
+
+// isConversationFile reports whether an uploaded document looks like a
+// conversation export
+func isConversationFile(doc *telebot.Document) bool {
+	return doc.MIME == "application/json" || strings.HasSuffix(strings.ToLower(doc.FileName), ".json")
+}
+
+// importConversation downloads and validates an uploaded conversation file. If
+// the chat already has history, the import is held until the user confirms.
+func importConversation(c telebot.Context, doc *telebot.Document) error {
+	if doc.FileSize > maxImportSize {
+		return c.Send(fmt.Sprintf("File too large (max %d KB).", maxImportSize/1024))
+	}
+
+	reader, err := bot.File(&doc.File)
+	if err != nil {
+		return c.Send("Failed to download file: " + err.Error())
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxImportSize+1))
+	if err != nil {
+		return c.Send("Failed to read file: " + err.Error())
+	}
+	if len(data) > maxImportSize {
+		return c.Send(fmt.Sprintf("File too large (max %d KB).", maxImportSize/1024))
+	}
+
+	export, err := parseConversationExport(data)
+	if err != nil {
+		return c.Send("Invalid conversation file: " + err.Error())
+	}
+
+	note := dropRefusedModel(c.Sender().ID, export)
+
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+
+	// Nothing to overwrite, import straight away
+	if len(state.History) == 0 {
+		applyConversationExport(state, export)
+		saveUserState(chatID, state)
+		return c.Send(importSummary(state) + note)
+	}
+
+	setPendingInput(state, "import")
+	state.PendingImport = export
+	saveUserState(chatID, state)
+	return c.Send(fmt.Sprintf("This will replace your current conversation (%d messages) with the uploaded one (%d messages).%s\n\nReply \"yes\" to confirm, anything else cancels.",
+		len(state.History), len(export.History), note))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// An imported conversation can't switch the chat to a model user_models
+// doesn't allow
+func TestImportKeepsModelWhenRefused(t *testing.T) {
+	viper.Set("user_models", map[string][]string{"default": {"allowed-*"}})
+	defer viper.Set("user_models", nil)
+
+	state := &UserState{Model: "allowed-small"}
+	export := &ConversationExport{
+		Version: conversationExportVersion,
+		Model:   "expensive-large",
+		History: []ChatMessage{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+	}
+	if note := dropRefusedModel(42, export); note == "" {
+		t.Error("no note for a refused model")
+	}
+	applyConversationExport(state, export)
+	if state.Model != "allowed-small" {
+		t.Errorf("model = %q, want the current one kept", state.Model)
+	}
+	if len(state.History) != 2 {
+		t.Errorf("history has %d messages, want the 2 imported", len(state.History))
+	}
+
+	export.Model = "allowed-large"
+	if note := dropRefusedModel(42, export); note != "" {
+		t.Errorf("note for an allowed model: %q", note)
+	}
+	applyConversationExport(state, export)
+	if state.Model != "allowed-large" {
+		t.Errorf("model = %q, want the allowed import", state.Model)
+	}
+}
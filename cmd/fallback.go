@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// The chat's own model is retried this many times, this far apart, before
+// fallback_models are tried
+const (
+	primaryRetries = 1
+	retryDelay     = 2 * time.Second
+)
+
+// isOverloaded reports whether an error means the model is temporarily
+// unavailable, so retrying or falling back may help
+func isOverloaded(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+		529: // Anthropic's "overloaded"
+		return true
+	}
+	return false
+}
+
+// isUnknownModel reports whether an error means the API doesn't have the
+// requested model: a 404, or a 400 or 422 whose message says so
+func isUnknownModel(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusNotFound:
+		return true
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		message := strings.ToLower(apiErr.Message)
+		if !strings.Contains(message, "model") {
+			return false
+		}
+		for _, hint := range []string{"not found", "does not exist", "unknown", "invalid model", "not available", "no such"} {
+			if strings.Contains(message, hint) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// switchModel replaces a chat's model that the API no longer has, keeping
+// the conversation with it under per_model_history
+func switchModel(state *UserState, model string) {
+	if state.HistoryModel == state.Model {
+		state.HistoryModel = model
+	}
+	state.Model = model
+}
+
+// requestReply sends a chat request and returns the reply text. If the API
+// rejects a request with a reasoning effort, it's sent again without one.
+func requestReply(ctx context.Context, state *UserState, reqBody ChatRequest, onPartial func(string)) (string, error) {
+	reply, err := requestReplyOnce(ctx, state, reqBody, onPartial)
+	if reqBody.ReasoningEffort != "" && effortRejected(err) && ctx.Err() == nil {
+		requestLogger(ctx).Warn("request with reasoning effort rejected, retrying without it",
+			slog.String("reasoning_effort", reqBody.ReasoningEffort), slog.Any("error", err))
+		reqBody.ReasoningEffort = ""
+		return requestReplyOnce(ctx, state, reqBody, onPartial)
+	}
+	return reply, err
+}
+
+// requestReplyOnce sends a chat request and returns the reply text
+func requestReplyOnce(ctx context.Context, state *UserState, reqBody ChatRequest, onPartial func(string)) (string, error) {
+	if !reqBody.Stream {
+		return chatWithTools(ctx, state, reqBody)
+	}
+
+	resp, err := postChat(ctx, reqBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	reply, usage, err := readChatStream(resp.Body, onPartial)
+	if err != nil {
+		requestLogger(ctx).Error("failed to read stream", slog.Any("error", err))
+		return "", err
+	}
+	if usage == nil {
+		requestLogger(ctx).Debug("stream didn't report usage, estimating it")
+		estimate := estimateUsage(reqBody, reply)
+		usage = &estimate
+	}
+	recordUsage(state, reqBody.Model, *usage)
+	tokensConsumed.WithLabelValues("prompt").Add(float64(usage.PromptTokens))
+	tokensConsumed.WithLabelValues("completion").Add(float64(usage.CompletionTokens))
+	return reply, nil
+}
+
+// requestWithFallback sends a chat request, retrying the model when it's
+// overloaded and then trying each of fallback_models once. Returns the reply
+// and the model that gave it.
+func requestWithFallback(ctx context.Context, state *UserState, reqBody ChatRequest, onPartial func(string)) (string, string, error) {
+	primary := reqBody.Model
+	var err error
+	for attempt := 0; attempt <= primaryRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+				return "", "", ctx.Err()
+			}
+		}
+		var reply string
+		reply, err = requestReply(ctx, state, reqBody, onPartial)
+		if !isOverloaded(err) {
+			return reply, primary, err
+		}
+		requestLogger(ctx).Warn("model overloaded", slog.String("model", primary), slog.Int("attempt", attempt+1), slog.Any("error", err))
+	}
+
+	for _, model := range viper.GetStringSlice("fallback_models") {
+		if model == primary {
+			continue
+		}
+		reqBody.Model = model
+		reply, fallbackErr := requestReply(ctx, state, reqBody, onPartial)
+		if fallbackErr == nil {
+			requestLogger(ctx).Info("answered by fallback model", slog.String("model", primary), slog.String("fallback", model))
+			return reply, model, nil
+		}
+		requestLogger(ctx).Warn("fallback model failed", slog.String("fallback", model), slog.Any("error", fallbackErr))
+	}
+	return "", primary, err
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// Ratings are appended to this file, one JSON object per line
+const feedbackPath = "./data/feedback.jsonl"
+
+// Answers per chat that can still be rated; the oldest lose their buttons'
+// effect first
+const maxRateableAnswers = 50
+
+var (
+	feedbackBtn = telebot.Btn{Unique: "feedback"}
+	feedbackMu  sync.Mutex
+)
+
+// RatedAnswer is what a rating is logged with, kept until the answer's
+// buttons are used
+type RatedAnswer struct {
+	Model      string `json:"model"`
+	PromptHash string `json:"prompt_hash"`
+}
+
+// feedbackRecord is one line of the feedback file
+type feedbackRecord struct {
+	Time       time.Time `json:"time"`
+	ChatID     int64     `json:"chat_id"`
+	UserID     int64     `json:"user_id"`
+	MessageID  int       `json:"message_id"`
+	Model      string    `json:"model"`
+	PromptHash string    `json:"prompt_hash"` // Lets ratings of the same prompt be compared without logging it
+	Rating     string    `json:"rating"`      // "up" or "down"
+}
+
+// promptHash identifies a prompt without revealing it
+func promptHash(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:8])
+}
+
+// feedbackMarkup returns the 👍/👎 buttons put under answers
+func feedbackMarkup() *telebot.ReplyMarkup {
+	markup := &telebot.ReplyMarkup{}
+	markup.Inline(markup.Row(
+		markup.Data("👍", feedbackBtn.Unique, "up"),
+		markup.Data("👎", feedbackBtn.Unique, "down"),
+	))
+	return markup
+}
+
+// offerFeedback puts rating buttons under the last message of an answer when
+// feedback_buttons is on, remembering the model and prompt for the rating
+func offerFeedback(chatID int64, sent []*telebot.Message, model, prompt string) {
+	state := cachedState(chatID)
+	if !viper.GetBool("feedback_buttons") || state == nil || len(sent) == 0 || sent[len(sent)-1] == nil {
+		return
+	}
+	last := sent[len(sent)-1]
+	if _, err := bot.EditReplyMarkup(last, feedbackMarkup()); err != nil {
+		logger.Warn("failed to add feedback buttons", slog.Int64("chat_id", chatID), slog.Any("error", err))
+		return
+	}
+
+	if state.Rateable == nil {
+		state.Rateable = make(map[int]RatedAnswer)
+	}
+	state.Rateable[last.ID] = RatedAnswer{Model: model, PromptHash: promptHash(prompt)}
+	if len(state.Rateable) > maxRateableAnswers {
+		ids := make([]int, 0, len(state.Rateable))
+		for id := range state.Rateable {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids[:len(ids)-maxRateableAnswers] {
+			delete(state.Rateable, id)
+		}
+	}
+	saveUserState(chatID, state)
+}
+
+// handleFeedbackButton logs the rating of a tapped 👍/👎 button and removes
+// the buttons, so each answer is rated once
+func handleFeedbackButton(c telebot.Context) error {
+	msg := c.Callback().Message
+	rating := c.Callback().Data
+	if msg == nil || (rating != "up" && rating != "down") {
+		return c.Respond()
+	}
+	state := loadUserState(c.Chat().ID)
+	cacheState(c.Chat().ID, state)
+	answer, ok := state.Rateable[msg.ID]
+	if !ok {
+		bot.EditReplyMarkup(msg, nil)
+		return c.Respond(&telebot.CallbackResponse{Text: "This answer can't be rated anymore."})
+	}
+
+	record := feedbackRecord{
+		Time:       time.Now().UTC(),
+		ChatID:     c.Chat().ID,
+		UserID:     c.Sender().ID,
+		MessageID:  msg.ID,
+		Model:      answer.Model,
+		PromptHash: answer.PromptHash,
+		Rating:     rating,
+	}
+	if err := appendFeedback(record); err != nil {
+		logger.Error("failed to save feedback", slog.Int64("chat_id", c.Chat().ID), slog.Any("error", err))
+		return c.Respond(&telebot.CallbackResponse{Text: "Sorry, your rating couldn't be saved."})
+	}
+	logger.Info("answer rated", slog.Int64("chat_id", c.Chat().ID), slog.String("model", answer.Model), slog.String("rating", rating))
+
+	delete(state.Rateable, msg.ID)
+	saveUserState(c.Chat().ID, state)
+	bot.EditReplyMarkup(msg, nil)
+	return c.Respond(&telebot.CallbackResponse{Text: "Thanks for the feedback!"})
+}
+
+// appendFeedback adds a rating to the feedback file
+func appendFeedback(record feedbackRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	feedbackMu.Lock()
+	defer feedbackMu.Unlock()
+	f, err := os.OpenFile(feedbackPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// feedbackSummary counts the ratings in the feedback file by model
+func feedbackSummary(data []byte) string {
+	type tally struct{ up, down int }
+	tallies := make(map[string]*tally)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var record feedbackRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			continue
+		}
+		t := tallies[record.Model]
+		if t == nil {
+			t = &tally{}
+			tallies[record.Model] = t
+		}
+		if record.Rating == "up" {
+			t.up++
+		} else {
+			t.down++
+		}
+	}
+	if len(tallies) == 0 {
+		return "No ratings yet."
+	}
+
+	models := make([]string, 0, len(tallies))
+	for model := range tallies {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	var b strings.Builder
+	b.WriteString("Ratings by model:\n")
+	for _, model := range models {
+		t := tallies[model]
+		fmt.Fprintf(&b, "\n%s: 👍 %d, 👎 %d (%.0f%% positive)", model, t.up, t.down, 100*float64(t.up)/float64(t.up+t.down))
+	}
+	b.WriteString("\n\n/feedback export downloads every rating.")
+	return b.String()
+}
+
+// handleFeedback implements /feedback: admins see ratings by model, and
+// /feedback export sends the feedback file
+func handleFeedback(c telebot.Context) error {
+	if userRole(c.Sender().ID) != roleAdmin {
+		if !viper.GetBool("feedback_buttons") {
+			return c.Send("Rating answers is turned off.")
+		}
+		return c.Send("Rate an answer with the 👍 or 👎 button under it. Ratings help pick the best models.")
+	}
+
+	feedbackMu.Lock()
+	data, err := os.ReadFile(feedbackPath)
+	feedbackMu.Unlock()
+	if os.IsNotExist(err) {
+		return c.Send("No ratings yet.")
+	}
+	if err != nil {
+		return c.Send("Failed to read feedback: " + err.Error())
+	}
+
+	if strings.TrimSpace(c.Message().Payload) != "export" {
+		return c.Send(feedbackSummary(data))
+	}
+	return c.Send(&telebot.Document{
+		File:     telebot.FromReader(bytes.NewReader(data)),
+		FileName: "feedback.jsonl",
+		MIME:     "application/x-ndjson",
+	})
+}
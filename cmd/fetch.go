@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Defaults for the url_fetch_* settings
+const (
+	defaultURLFetchMaxBytes    = 512 * 1024
+	defaultURLFetchTimeoutSecs = 15
+)
+
+// Most links fetched from one message
+const maxFetchedURLs = 3
+
+var (
+	urlPattern       = regexp.MustCompile(`https?://[^\s<>"']+`)
+	htmlSkipPattern  = regexp.MustCompile(`(?is)<(script|style|noscript|head|svg)\b.*?</(script|style|noscript|head|svg)>`)
+	htmlBreakPattern = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/tr|/h[1-6]|/section|/article)\b[^>]*>`)
+	htmlTagPattern   = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLinePattern = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// fetchClient is used for user-supplied links. It refuses to connect to
+// loopback and private addresses so links can't reach the bot's own network.
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+					return fmt.Errorf("address %s is not public", host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return fmt.Errorf("too many redirects")
+		}
+		if !urlDomainAllowed(req.URL.Hostname()) {
+			return fmt.Errorf("redirected to blocked domain %s", req.URL.Hostname())
+		}
+		return nil
+	},
+}
+
+// domainMatches reports whether host is domain or one of its subdomains
+func domainMatches(host, domain string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// urlDomainAllowed checks host against url_fetch_deny and, when set,
+// url_fetch_allow. Deny entries win.
+func urlDomainAllowed(host string) bool {
+	for _, d := range viper.GetStringSlice("url_fetch_deny") {
+		if domainMatches(host, d) {
+			return false
+		}
+	}
+	allow := viper.GetStringSlice("url_fetch_allow")
+	if len(allow) == 0 {
+		return true
+	}
+	for _, d := range allow {
+		if domainMatches(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlToText strips a page down to its readable text
+func htmlToText(page string) string {
+	page = htmlSkipPattern.ReplaceAllString(page, "")
+	page = htmlBreakPattern.ReplaceAllString(page, "\n")
+	page = htmlTagPattern.ReplaceAllString(page, "")
+	page = html.UnescapeString(page)
+
+	lines := strings.Split(page, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(blankLinePattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// fetchPageText downloads a link and returns its text, reading at most
+// url_fetch_max_bytes
+func fetchPageText(ctx context.Context, link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	if !urlDomainAllowed(u.Hostname()) {
+		return "", fmt.Errorf("domain %s is not allowed", u.Hostname())
+	}
+
+	timeoutSecs := viper.GetInt("url_fetch_timeout_secs")
+	if timeoutSecs <= 0 {
+		timeoutSecs = defaultURLFetchTimeoutSecs
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSecs)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "telegram-llm-bot")
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	isHTML := strings.Contains(contentType, "html")
+	if !isHTML && !strings.HasPrefix(contentType, "text/") && !strings.Contains(contentType, "json") {
+		return "", fmt.Errorf("unsupported content type %q", contentType)
+	}
+
+	maxBytes := viper.GetInt64("url_fetch_max_bytes")
+	if maxBytes <= 0 {
+		maxBytes = defaultURLFetchMaxBytes
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes))
+	if err != nil {
+		return "", err
+	}
+
+	text := string(body)
+	if isHTML {
+		text = htmlToText(text)
+	}
+	if text == "" {
+		return "", fmt.Errorf("page has no text")
+	}
+	return text, nil
+}
+
+// fetchURLs returns the text of the links in a message when url_fetch is
+// enabled, to be sent ahead of it, and a note for each link that couldn't be
+// fetched
+func fetchURLs(ctx context.Context, message string) (string, []string) {
+	if !viper.GetBool("url_fetch") {
+		return "", nil
+	}
+
+	var pages, failures []string
+	seen := make(map[string]bool)
+	for _, link := range urlPattern.FindAllString(message, -1) {
+		link = strings.TrimRight(link, ".,;:!?)]")
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+		if len(seen) > maxFetchedURLs {
+			failures = append(failures, fmt.Sprintf("Skipped %s: only %d links are fetched per message.", link, maxFetchedURLs))
+			continue
+		}
+		text, err := fetchPageText(ctx, link)
+		if err != nil {
+			requestLogger(ctx).Warn("url fetch failed", slog.String("url", link), slog.Any("error", err))
+			failures = append(failures, fmt.Sprintf("Couldn't fetch %s: %v", link, err))
+			continue
+		}
+		pages = append(pages, "Content of "+link+":\n"+text)
+	}
+	return strings.Join(pages, "\n\n"), failures
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+// forgetMessage removes the history entry at index i together with the other
+// half of its exchange: the answer after a question, or the question before
+// an answer. Reply positions after it move down so replies to later answers
+// still branch from the right place. Returns the number of entries removed.
+func forgetMessage(state *UserState, i int) int {
+	start, end := i, i+1
+	history := state.History
+	if history[i].Role == "user" && end < len(history) && history[end].Role == "assistant" {
+		end++
+	} else if history[i].Role == "assistant" && start > 0 && history[start-1].Role == "user" {
+		start--
+	}
+	removed := end - start
+
+	if end == len(history) {
+		// The latest exchange is gone, so its message can't be edited and resent
+		state.LastMessageID = 0
+	}
+	state.History = append(history[:start:start], history[end:]...)
+	for id, pos := range state.ReplyIndex {
+		switch {
+		case pos >= end:
+			state.ReplyIndex[id] = pos - removed
+		case pos > start:
+			state.ReplyIndex[id] = start
+		}
+	}
+	return removed
+}
+
+// handleForget implements /forget <n>: message n as numbered by /history
+// show is removed from the conversation along with its question or answer
+func handleForget(c telebot.Context) error {
+	state := loadUserState(c.Chat().ID)
+	cacheState(c.Chat().ID, state)
+	arg := strings.TrimSpace(c.Message().Payload)
+	if arg == "" {
+		return c.Send("Usage: /forget <n> - remove message n and its question or answer from the conversation. /history show numbers the messages.")
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(state.History) {
+		if len(state.History) == 0 {
+			return c.Send("The conversation is empty.")
+		}
+		return c.Send(fmt.Sprintf("Pick a message from 1 to %d, as numbered by /history show.", len(state.History)))
+	}
+
+	forgotten := state.History[n-1]
+	removed := forgetMessage(state, n-1)
+	saveUserState(c.Chat().ID, state)
+	if removed == 2 {
+		return c.Send(fmt.Sprintf("Removed message %d and its %s:\n\n%s", n, pairedRole(forgotten.Role), truncateText(forgotten.Content, 200)))
+	}
+	return c.Send(fmt.Sprintf("Removed message %d:\n\n%s", n, truncateText(forgotten.Content, 200)))
+}
+
+// pairedRole names the other half of an exchange
+func pairedRole(role string) string {
+	if role == "user" {
+		return "answer"
+	}
+	return "question"
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	startTime = time.Now()
+	botReady  atomic.Bool // Set once the bot has connected to Telegram
+)
+
+// healthStatus is the JSON body of /healthz and /readyz
+type healthStatus struct {
+	Status      string `json:"status"`
+	BotUsername string `json:"bot_username,omitempty"`
+	UptimeSecs  int64  `json:"uptime_secs"`
+	Error       string `json:"error,omitempty"`
+}
+
+func writeHealth(w http.ResponseWriter, code int, status healthStatus) {
+	status.UptimeSecs = int64(time.Since(startTime).Seconds())
+	if botReady.Load() {
+		status.BotUsername = bot.Me.Username
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleHealthz reports that the process is up
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// handleReadyz reports ready once the bot has connected, and with
+// health_check_backend also requires the API's model list to be reachable
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !botReady.Load() {
+		writeHealth(w, http.StatusServiceUnavailable, healthStatus{Status: "starting"})
+		return
+	}
+	if viper.GetBool("health_check_backend") {
+		if _, err := fetchModels(); err != nil {
+			writeHealth(w, http.StatusServiceUnavailable, healthStatus{Status: "backend unavailable", Error: err.Error()})
+			return
+		}
+	}
+	writeHealth(w, http.StatusOK, healthStatus{Status: "ready"})
+}
+
+// startHealthServer serves /healthz and /readyz on addr
+func startHealthServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+
+	go func() {
+		logger.Info("health server listening", slog.String("addr", addr))
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error("health server failed", slog.Any("error", err))
+		}
+	}()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// defaultMessages are the built-in English texts of the messages operators
+// can translate with the messages setting, by message ID
+var defaultMessages = map[string]string{
+	"welcome":         defaultWelcomeMessage,
+	"thinking":        "🤖 thinking…",
+	"no_response":     "No response received.",
+	"timeout":         "Request timed out. Try a shorter prompt or increase timeout_secs in config.",
+	"cancelled":       "Request cancelled.",
+	"error":           "Error: {{error}}",
+	"queued":          "Queued, position {{position}} in line.",
+	"queue_full":      "Too many messages waiting ({{depth}} of {{max}}). This one was not queued, please send it again once I've caught up.",
+	"queue_full_wait": "Too many messages waiting ({{depth}} of {{max}}). This one was not queued, please send it again in about {{wait}}.",
+}
+
+// translation returns the messages entry for a message in a Telegram
+// language code such as "pt-br", falling back to its base language ("pt").
+// Returns "" if neither is translated.
+func translation(lang, id string) string {
+	lang = strings.ToLower(lang)
+	base, _, _ := strings.Cut(lang, "-")
+	for _, l := range []string{lang, base} {
+		if l == "" {
+			continue
+		}
+		if text := viper.GetString("messages." + l + "." + id); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// localize returns a message in the user's language, or in English if it
+// isn't translated. vars are placeholder and value pairs, e.g.
+// "{{error}}", err.Error().
+func localize(lang, id string, vars ...string) string {
+	text := translation(lang, id)
+	if text == "" {
+		text = defaultMessages[id]
+	}
+	if len(vars) == 0 {
+		return text
+	}
+	return strings.NewReplacer(vars...).Replace(text)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Inline answers have to arrive while the user is still looking at the
+// query, so they're short and time-boxed
+const (
+	inlineTimeout   = 10 * time.Second
+	inlineMaxTokens = 500
+	inlineMaxAnswer = 3500 // characters, leaving room for the DM hint
+)
+
+// handleInlineQuery answers "@bot <question>" in any chat. The question is
+// sent without the user's history and doesn't change it.
+func handleInlineQuery(c telebot.Context) error {
+	question := strings.TrimSpace(c.Query().Text)
+	if question == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), inlineTimeout)
+	defer cancel()
+	answer, err := sendChat(ctx, c.Sender().ID, question, chatOptions{
+		Username:  senderName(c.Sender().FirstName, c.Sender().Username),
+		Stateless: true,
+		MaxTokens: inlineMaxTokens,
+		UserID:    c.Sender().ID,
+	})
+
+	result := &telebot.ArticleResult{Title: truncateText(question, 64)}
+	switch {
+	case err != nil:
+		logger.Warn("inline query failed", slog.Int64("user_id", c.Sender().ID), slog.Any("error", err))
+		result.Description = "Couldn't get an answer in time. Ask me in a DM instead."
+		result.Text = question
+	case answer == "":
+		result.Description = "No answer received."
+		result.Text = question
+	default:
+		if utf8.RuneCountInString(answer) > inlineMaxAnswer {
+			answer = truncateText(answer, inlineMaxAnswer) + "\n\nContinue in a DM with @" + bot.Me.Username
+		}
+		result.Description = truncateText(answer, 100)
+		result.Text = answer
+	}
+
+	return c.Answer(&telebot.QueryResponse{
+		Results:    telebot.Results{result},
+		CacheTime:  0,
+		IsPersonal: true,
+	})
+}
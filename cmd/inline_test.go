@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// An inline query is answered with the chat's settings but must leave its
+// state as it was: no attachment turn used, no answered message recorded, no
+// model switch, nothing saved
+func TestStatelessChatLeavesStateAlone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model == "gone-model" {
+			http.Error(w, `{"error":{"message":"model not found"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(ChatResponse{
+			Choices: []Choice{{Message: Message{Content: "42"}}},
+			Usage:   &TokenUsage{PromptTokens: 10, CompletionTokens: 2},
+		})
+	}))
+	defer server.Close()
+	viper.Set("api_endpoint", server.URL)
+	viper.Set("default_model", "default-model")
+	defer viper.Set("api_endpoint", nil)
+	defer viper.Set("default_model", nil)
+
+	// Anything saved would land under ./data
+	dir, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(dir)
+
+	const chatID = 1001
+	for _, model := range []string{"some-model", "gone-model"} {
+		state := &UserState{
+			Model:             model,
+			SystemPrompt:      "Be brief.",
+			SystemEnabled:     true,
+			History:           []ChatMessage{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}},
+			AnsweredMessageID: 5,
+			Attachment:        &Attachment{Name: "notes.txt", Content: "notes", TurnsLeft: 1},
+		}
+		before, _ := json.Marshal(state)
+		cacheState(chatID, state)
+
+		reply, err := sendChat(context.Background(), chatID, "what is 6*7?", chatOptions{Stateless: true, MessageID: 9})
+		if model == "some-model" && (err != nil || !strings.Contains(reply, "42")) {
+			t.Errorf("%s: reply = %q, %v", model, reply, err)
+		}
+		if model == "gone-model" && err == nil {
+			t.Errorf("%s: answered by switching models", model)
+		}
+
+		after, _ := json.Marshal(cachedState(chatID))
+		if cachedState(chatID) != state || !reflect.DeepEqual(before, after) {
+			t.Errorf("%s: state changed\nbefore %s\nafter  %s", model, before, after)
+		}
+		if _, err := os.Stat("data"); err == nil {
+			t.Errorf("%s: state was saved", model)
+		}
+	}
+	uncacheState(chatID)
+}
+
+// Text passed as Context, like fetched links, reaches the model ahead of the
+// message but only the user's own text is kept in the history
+func TestRequestContextKeptOutOfHistory(t *testing.T) {
+	var sent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		sent = req.Messages[len(req.Messages)-1].Content
+		json.NewEncoder(w).Encode(ChatResponse{Choices: []Choice{{Message: Message{Content: "It's about cats."}}}})
+	}))
+	defer server.Close()
+	viper.Set("api_endpoint", server.URL)
+	defer viper.Set("api_endpoint", nil)
+
+	dir, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(dir)
+
+	const chatID = 1002
+	state := &UserState{Model: "some-model"}
+	cacheState(chatID, state)
+	defer uncacheState(chatID)
+
+	const page = "Content of https://example.com:\nA page about cats."
+	const message = "What is https://example.com about?"
+	if _, err := sendChat(context.Background(), chatID, message, chatOptions{Context: page}); err != nil {
+		t.Fatalf("sendChat: %v", err)
+	}
+	if want := page + "\n\n" + message; sent != want {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+	if len(state.History) != 2 || state.History[0].Content != message {
+		t.Errorf("history = %+v, want the message without the page", state.History)
+	}
+}
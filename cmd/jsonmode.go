@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"html"
+	"log/slog"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+// ResponseFormat is OpenAI's response_format, sent as json_object in JSON
+// mode
+type ResponseFormat struct {
+	Type string `json:"type"`
+}
+
+// sendJSONReply delivers a JSON mode answer as a code block instead of
+// converting it from markdown, in place of the placeholder if it fits
+func sendJSONReply(ctx context.Context, chat *telebot.Chat, placeholder *telebot.Message, response string) []*telebot.Message {
+	block := `<pre><code class="language-json">` + html.EscapeString(response) + "</code></pre>"
+	if visibleLen(block) <= maxMessageLen() {
+		if placeholder != nil {
+			if _, err := bot.Edit(placeholder, block, telebot.ModeHTML); err == nil {
+				return []*telebot.Message{placeholder}
+			}
+		} else if msg, err := bot.Send(chat, block, telebot.ModeHTML); err == nil {
+			return []*telebot.Message{msg}
+		}
+	}
+
+	if placeholder != nil {
+		bot.Delete(placeholder)
+	}
+	if sent := sendHTMLChunks(ctx, chat, block); len(sent) > 0 {
+		return sent
+	}
+	requestLogger(ctx).Warn("JSON code block refused, sending plain")
+	sent, err := splitAndSend(chat, response)
+	if err != nil {
+		requestLogger(ctx).Error("failed to send JSON reply", slog.Any("error", err))
+	}
+	return sent
+}
+
+// handleJSON implements /json on|off
+func handleJSON(c telebot.Context) error {
+	state := loadUserState(c.Chat().ID)
+	cacheState(c.Chat().ID, state)
+	args := c.Args()
+	if len(args) < 1 || (args[0] != "on" && args[0] != "off") {
+		mode := "off"
+		if state.JSONMode {
+			mode = "on"
+		}
+		return c.Send("JSON mode is " + mode + ".\nUsage: /json on|off")
+	}
+	state.JSONMode = args[0] == "on"
+	saveUserState(c.Chat().ID, state)
+	if !state.JSONMode {
+		return c.Send("JSON mode off: answers are formatted as usual again.")
+	}
+
+	msg := "JSON mode on: answers are requested as a JSON object and shown as a code block, until /json off.\n\nThe API requires your prompt to ask for JSON, or it may refuse the request."
+	if !state.SystemEnabled || !strings.Contains(strings.ToLower(state.SystemPrompt), "json") {
+		msg += " Your system prompt doesn't mention JSON yet; set one with /system that describes the JSON you want."
+	}
+	return c.Send(msg)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// A token longer than the limit, like a URL or base64 blob, is hard-split so
+// no chunk is refused by Telegram
+func TestSplitMessageLongToken(t *testing.T) {
+	token := strings.Repeat("aB3+/", 1800) // 9000 characters, no spaces
+	chunks := splitMessage(token, maxMessageLen())
+	if len(chunks) < 3 {
+		t.Fatalf("got %d chunks, want at least 3", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if n := textLen(chunk); n > maxMessageLen() {
+			t.Errorf("chunk %d is %d long, over the limit of %d", i, n, maxMessageLen())
+		}
+	}
+	if joined := strings.Join(chunks, ""); joined != token {
+		t.Errorf("chunks don't join back to the token: got %d bytes, want %d", len(joined), len(token))
+	}
+}
+
+// Chinese text and emoji are split between whole characters: every chunk is
+// valid UTF-8, fits Telegram's UTF-16 count, and no surrogate pair, emoji
+// sequence, flag or accented letter is cut in two
+func TestSplitMessageUnicode(t *testing.T) {
+	graphemes := []string{
+		"你", "好", "世", "界", "😀", "👍🏽", "👨‍👩‍👧‍👦", "🇩🇪", "🇯🇵", "❤️", "e\u0301", "🏳️‍🌈", "。",
+	}
+	var b strings.Builder
+	boundaries := map[int]bool{0: true}
+	for i := 0; b.Len() < 30000; i++ {
+		b.WriteString(graphemes[i%len(graphemes)])
+		boundaries[b.Len()] = true
+	}
+	text := b.String()
+
+	for _, limit := range []int{maxMessageLen(), 101, 12} {
+		t.Run(fmt.Sprint(limit), func(t *testing.T) {
+			chunks := splitMessage(text, limit)
+			offset := 0
+			for i, chunk := range chunks {
+				if !utf8.ValidString(chunk) {
+					t.Fatalf("chunk %d isn't valid UTF-8", i)
+				}
+				if n := textLen(chunk); n > limit {
+					t.Errorf("chunk %d is %d UTF-16 units, over the limit of %d", i, n, limit)
+				}
+				if !boundaries[offset] {
+					t.Errorf("chunk %d starts inside a character: %q", i, chunk[:min(len(chunk), 16)])
+				}
+				offset += len(chunk)
+			}
+			if joined := strings.Join(chunks, ""); joined != text {
+				t.Errorf("chunks don't join back to the text")
+			}
+		})
+	}
+}
+
+// checkLegacyMarkdown fails like Telegram's (legacy) Markdown parser does on
+// an entity that's never closed, the cause of "can't parse entities" errors
+func checkLegacyMarkdown(text string) error {
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '\\':
+			if i+1 < len(text) && strings.IndexByte("_*`[", text[i+1]) >= 0 {
+				i++
+			}
+		case '_', '*', '`':
+			end := strings.IndexByte(text[i+1:], c)
+			if end < 0 {
+				return fmt.Errorf("can't find end of the entity starting at byte offset %d", i)
+			}
+			i += end + 1
+		case '[':
+			end := strings.Index(text[i:], "](")
+			if end < 0 || !strings.Contains(text[i+end:], ")") {
+				return fmt.Errorf("can't find end of the link starting at byte offset %d", i)
+			}
+		}
+	}
+	return nil
+}
+
+// User-set text full of Markdown characters doesn't break /status
+func TestStatusMessageEscapesMarkdown(t *testing.T) {
+	prompt := "You are a *strict* reviewer_bot. Reply as `json`: {\"file_name\": [1, 2]} " +
+		"_*[]()~`>#+-=|{}.!\\ and an unclosed [link( or * or _ or ` here"
+	if err := checkLegacyMarkdown(prompt); err == nil {
+		t.Fatal("checkLegacyMarkdown accepts the raw prompt, so it can't catch a regression")
+	}
+
+	states := map[string]*UserState{
+		"system on":  {Model: "gpt_4o*mini", SystemPrompt: prompt, SystemEnabled: true},
+		"system off": {Model: "[model]", SystemPrompt: prompt},
+		"everything": {
+			Model: "org/model_v2", LockedModel: "`locked`_model", SystemPrompt: prompt, SystemEnabled: true,
+			ActiveSetup: "role sql_*expert*", Title: "Fix `foo_bar` [draft",
+		},
+	}
+	for name, state := range states {
+		msg := statusMessage(state)
+		if err := checkLegacyMarkdown(msg); err != nil {
+			t.Errorf("%s: %v\n%s", name, err, msg)
+		}
+		if !strings.Contains(msg, "*Current Status*") {
+			t.Errorf("%s: the heading lost its formatting:\n%s", name, msg)
+		}
+	}
+}
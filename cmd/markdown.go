@@ -0,0 +1,465 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	htmlTagToken          = regexp.MustCompile(`</?([a-zA-Z][a-zA-Z0-9-]*)\b[^>]*>`)
+	tableSeparatorPattern = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	tableCellMarkup       = strings.NewReplacer("**", "", "__", "", "`", "")
+
+	// Tags, entities, line breaks, words and runs of spaces; a stray < or &
+	// is a token of its own
+	htmlSplitToken = regexp.MustCompile(`<[^>]*>|&#?\w+;|\n|[^<&\s]+|[^\S\n]+|.`)
+)
+
+// Placeholder for a converted table while the rest of the text is formatted
+const tablePlaceholder = "\x00TABLE%d\x00"
+
+// isTableRow reports whether a line looks like a row of a pipe table
+func isTableRow(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "|") || (strings.Count(line, "|") >= 2 && !strings.HasPrefix(line, "```"))
+}
+
+// extractTables replaces Markdown tables in text with placeholders and
+// returns the tables rendered as aligned monospace text, since Telegram can't
+// show HTML tables. A table is a header row followed by a separator row, or
+// two or more rows that start with a pipe when the separator is missing.
+func extractTables(text string) (string, []string) {
+	lines := strings.Split(text, "\n")
+	var out, tables []string
+	inCode := false
+	for i := 0; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+			inCode = !inCode
+		}
+		if inCode || !isTableRow(lines[i]) {
+			out = append(out, lines[i])
+			continue
+		}
+
+		end := i
+		for end < len(lines) && isTableRow(lines[end]) {
+			end++
+		}
+		block := lines[i:end]
+		hasSeparator := len(block) >= 2 && tableSeparatorPattern.MatchString(block[1])
+		startsWithPipe := true
+		for _, line := range block {
+			startsWithPipe = startsWithPipe && strings.HasPrefix(strings.TrimSpace(line), "|")
+		}
+		if !hasSeparator && (len(block) < 2 || !startsWithPipe) {
+			out = append(out, lines[i])
+			continue
+		}
+
+		out = append(out, fmt.Sprintf(tablePlaceholder, len(tables)))
+		tables = append(tables, renderTable(block, hasSeparator))
+		i = end - 1
+	}
+	return strings.Join(out, "\n"), tables
+}
+
+// restoreTables puts the rendered tables back in place of their
+// placeholders, HTML-escaped inside <pre>
+func restoreTables(text string, tables []string) string {
+	for i, table := range tables {
+		table = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(table)
+		text = strings.Replace(text, fmt.Sprintf(tablePlaceholder, i), "<pre>"+table+"</pre>", 1)
+	}
+	return text
+}
+
+// splitTableRow returns the cells of a table row. A pipe escaped as \| or
+// inside a code span is part of its cell.
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = strings.TrimSuffix(line, "|")
+	}
+
+	var cells []string
+	var cell strings.Builder
+	inCode := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case c == '`':
+			inCode = !inCode
+			cell.WriteByte(c)
+		case c == '|' && !inCode:
+			cells = append(cells, cell.String())
+			cell.Reset()
+		default:
+			cell.WriteByte(c)
+		}
+	}
+	cells = append(cells, cell.String())
+	for i, cell := range cells {
+		cells[i] = tableCellMarkup.Replace(strings.TrimSpace(cell))
+	}
+	return cells
+}
+
+// renderTable lays out table rows in aligned columns, padding short rows.
+// The separator row, if any, sets each column's alignment.
+func renderTable(rows []string, hasSeparator bool) string {
+	var cells [][]string
+	var align []string
+	for i, row := range rows {
+		if hasSeparator && i == 1 {
+			align = splitTableRow(row)
+			continue
+		}
+		cells = append(cells, splitTableRow(row))
+	}
+
+	columns := 0
+	for _, row := range cells {
+		columns = max(columns, len(row))
+	}
+	widths := make([]int, columns)
+	for _, row := range cells {
+		for j, cell := range row {
+			widths[j] = max(widths[j], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var lines []string
+	for i, row := range cells {
+		var b strings.Builder
+		for j := 0; j < columns; j++ {
+			cell := ""
+			if j < len(row) {
+				cell = row[j]
+			}
+			spec := ""
+			if j < len(align) {
+				spec = align[j]
+			}
+			if j > 0 {
+				b.WriteString(" | ")
+			}
+			b.WriteString(padCell(cell, widths[j], spec))
+		}
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+		if i == 0 && hasSeparator {
+			rule := make([]string, columns)
+			for j, w := range widths {
+				rule[j] = strings.Repeat("-", w)
+			}
+			lines = append(lines, strings.Join(rule, "-+-"))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// padCell pads a cell to width following a separator cell's alignment
+// (":-" left, "-:" right, ":-:" centered)
+func padCell(cell string, width int, spec string) string {
+	gap := width - utf8.RuneCountInString(cell)
+	switch {
+	case strings.HasPrefix(spec, ":") && strings.HasSuffix(spec, ":"):
+		left := gap / 2
+		cell = strings.Repeat(" ", left) + cell + strings.Repeat(" ", gap-left)
+	case strings.HasSuffix(spec, ":"):
+		cell = strings.Repeat(" ", gap) + cell
+	default:
+		cell += strings.Repeat(" ", gap)
+	}
+	return cell
+}
+
+// Tags Telegram's HTML parse mode accepts
+var telegramTags = map[string]bool{
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true,
+	"s": true, "strike": true, "del": true, "a": true, "code": true, "pre": true,
+	"blockquote": true, "span": true, "tg-spoiler": true, "tg-emoji": true,
+}
+
+// sanitizeTelegramHTML removes tags Telegram doesn't support, keeping their
+// content, since one unknown tag makes the whole message fail to send
+func sanitizeTelegramHTML(text string) string {
+	return htmlTagToken.ReplaceAllStringFunc(text, func(tag string) string {
+		name := htmlTagToken.FindStringSubmatch(tag)[1]
+		if telegramTags[strings.ToLower(name)] {
+			return tag
+		}
+		return ""
+	})
+}
+
+// visibleLen is the length Telegram counts against its message limit for
+// HTML: the text once tags are dropped and entities decoded
+func visibleLen(text string) int {
+	return textLen(html.UnescapeString(htmlTagToken.ReplaceAllString(text, "")))
+}
+
+// splitHTML breaks Telegram HTML into chunks of at most maxLen visible
+// characters, as measured by visibleLen. Tags open at a cut are closed at the end of the chunk and
+// reopened at the start of the next, so each chunk is valid on its own. Cuts
+// fall after a line break where one is in the second half of the chunk,
+// otherwise between words, and words longer than a chunk are cut between
+// runes. Tags and entities are never cut.
+func splitHTML(text string, maxLen int) []string {
+	tokens := htmlSplitToken.FindAllString(text, -1)
+
+	var chunks []string
+	var chunk strings.Builder
+	var open []string // Opening tags in effect, outermost first
+	var size int      // Visible length of chunk
+	var hasText bool  // Whether chunk holds more than reopened tags
+
+	// Where the chunk can be cut after its last line break
+	type lineBreak struct {
+		token, bytes, size int
+		open               []string
+	}
+	var lastBreak *lineBreak
+
+	closers := func(open []string) string {
+		var b strings.Builder
+		for i := len(open) - 1; i >= 0; i-- {
+			b.WriteString("</" + htmlTagToken.FindStringSubmatch(open[i])[1] + ">")
+		}
+		return b.String()
+	}
+	flush := func() {
+		chunks = append(chunks, chunk.String()+closers(open))
+		chunk.Reset()
+		chunk.WriteString(strings.Join(open, ""))
+		size, hasText, lastBreak = visibleLen(chunk.String()), false, nil
+	}
+
+	for i := 0; i < len(tokens); {
+		tok := tokens[i]
+		isTag := htmlTagToken.MatchString(tok)
+
+		// Whitespace isn't carried to the start of a chunk outside tags
+		if !hasText && !isTag && len(open) == 0 && strings.TrimSpace(tok) == "" {
+			i++
+			continue
+		}
+
+		next := open
+		if isTag {
+			name := htmlTagToken.FindStringSubmatch(tok)[1]
+			if strings.HasPrefix(tok, "</") {
+				if n := len(open); n > 0 && htmlTagToken.FindStringSubmatch(open[n-1])[1] == name {
+					next = open[:n-1]
+				}
+			} else {
+				next = append(open[:len(open):len(open)], tok)
+			}
+		}
+
+		if size+visibleLen(tok)+visibleLen(closers(next)) <= maxLen {
+			chunk.WriteString(tok)
+			size += visibleLen(tok)
+			open = next
+			hasText = hasText || !isTag
+			i++
+			if tok == "\n" {
+				lastBreak = &lineBreak{token: i, bytes: chunk.Len(), size: size, open: open}
+			}
+			continue
+		}
+
+		if hasText {
+			if lastBreak != nil && lastBreak.size > maxLen/2 {
+				kept := chunk.String()[:lastBreak.bytes]
+				chunk.Reset()
+				chunk.WriteString(kept)
+				i, open = lastBreak.token, lastBreak.open
+			}
+			flush()
+			continue
+		}
+
+		// Nothing but reopened tags yet, so the word doesn't fit any chunk:
+		// cut it to fit
+		room := maxLen - size - visibleLen(closers(open))
+		cut := len(tok)
+		if !isTag {
+			cut = textPrefix(tok, room)
+		}
+		if cut == 0 {
+			cut = len(tok)
+		}
+		chunk.WriteString(tok[:cut])
+		hasText = true
+		if cut == len(tok) {
+			open = next
+			i++
+		} else {
+			tokens[i] = tok[cut:]
+		}
+		flush()
+	}
+	if hasText {
+		chunks = append(chunks, chunk.String()+closers(open))
+	}
+	return chunks
+}
+
+// delimiterRun is a run of * or _ that may open or close emphasis
+type delimiterRun struct {
+	char      byte
+	piece     int // Index of the run in the line's pieces
+	remaining int // Characters not yet matched
+	canOpen   bool
+	canClose  bool
+	openTags  string // Tags this run opens, written after its leftover characters
+	closeTags string // Tags this run closes, written before its leftover characters
+}
+
+// isPunctuation reports whether r counts as punctuation for flanking
+func isPunctuation(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// delimiterFlanks reports whether a delimiter run between prev and next can
+// open and close emphasis, following CommonMark's flanking rules for _ for
+// both * and _. Line edges count as spaces. A run is left-flanking when
+// text follows it and it isn't stuck to the end of a word (it's either not
+// followed by punctuation, or preceded by a space or punctuation), and
+// right-flanking the other way round. A run flanked both ways sits inside a
+// word, as in snake_case or 2*3*4, and only opens or closes next to
+// punctuation.
+func delimiterFlanks(prev, next rune) (canOpen, canClose bool) {
+	left := !unicode.IsSpace(next) &&
+		(!isPunctuation(next) || unicode.IsSpace(prev) || isPunctuation(prev))
+	right := !unicode.IsSpace(prev) &&
+		(!isPunctuation(prev) || unicode.IsSpace(next) || isPunctuation(next))
+	canOpen = left && (!right || isPunctuation(prev))
+	canClose = right && (!left || isPunctuation(next))
+	return canOpen, canClose
+}
+
+// formatEmphasis converts ** and __ to bold and * and _ to italics. Each line
+// is tokenized into delimiter runs, which are paired up from the innermost
+// out as in CommonMark: runs of two or more make bold, single ones italics,
+// and runs that find no partner are left as typed. Code spans, fenced
+// blocks and links are left untouched.
+func formatEmphasis(text string) string {
+	lines := strings.Split(text, "\n")
+	inFence := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			continue
+		}
+		if !inFence {
+			lines[i] = formatLineEmphasis(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatLineEmphasis(line string) string {
+	var pieces []string
+	var runs []*delimiterRun
+	var stack []*delimiterRun // Runs that may still open, innermost last
+
+	// Underscores in a link are part of its address
+	links := urlPattern.FindAllStringIndex(line, -1)
+	nextLink := func(i int) []int {
+		for len(links) > 0 && links[0][1] <= i {
+			links = links[1:]
+		}
+		if len(links) > 0 {
+			return links[0]
+		}
+		return []int{len(line), len(line)}
+	}
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		if link := nextLink(i); link[0] == i {
+			pieces = append(pieces, line[i:link[1]])
+			i = link[1]
+			continue
+		}
+		if c == '`' {
+			end := strings.IndexByte(line[i+1:], '`')
+			if end < 0 {
+				pieces = append(pieces, line[i:])
+				break
+			}
+			pieces = append(pieces, line[i:i+end+2])
+			i += end + 2
+			continue
+		}
+		if c != '*' && c != '_' {
+			j, end := i+1, nextLink(i)[0]
+			for j < end && line[j] != '*' && line[j] != '_' && line[j] != '`' {
+				j++
+			}
+			pieces = append(pieces, line[i:j])
+			i = j
+			continue
+		}
+
+		j := i
+		for j < len(line) && line[j] == c {
+			j++
+		}
+		prev, next := ' ', ' '
+		if i > 0 {
+			prev, _ = utf8.DecodeLastRuneInString(line[:i])
+		}
+		if j < len(line) {
+			next, _ = utf8.DecodeRuneInString(line[j:])
+		}
+		run := &delimiterRun{char: c, piece: len(pieces), remaining: j - i}
+		run.canOpen, run.canClose = delimiterFlanks(prev, next)
+		pieces = append(pieces, "")
+		runs = append(runs, run)
+		i = j
+
+		// Close the nearest opener of the same kind, innermost pair first
+		for run.canClose && run.remaining > 0 {
+			k := len(stack) - 1
+			for k >= 0 && stack[k].char != c {
+				k--
+			}
+			if k < 0 {
+				break
+			}
+			opener := stack[k]
+			n := 1
+			tag := "i"
+			if opener.remaining >= 2 && run.remaining >= 2 {
+				n, tag = 2, "b"
+			}
+			opener.remaining -= n
+			run.remaining -= n
+			opener.openTags = "<" + tag + ">" + opener.openTags
+			run.closeTags += "</" + tag + ">"
+			// Runs between the pair can no longer be matched
+			stack = stack[:k+1]
+			if opener.remaining == 0 {
+				stack = stack[:k]
+			}
+		}
+		if run.canOpen && run.remaining > 0 {
+			stack = append(stack, run)
+		}
+	}
+
+	for _, run := range runs {
+		literal := strings.Repeat(string(run.char), run.remaining)
+		pieces[run.piece] = run.closeTags + literal + run.openTags
+	}
+	return strings.Join(pieces, "")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestConvertMarkdownEmphasis(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"5 * 3 = 15", "5 * 3 = 15"},
+		{"2*3*4", "2*3*4"},
+		{"**b** then *i*", "<b>b</b> then <i>i</i>"},
+		{"*a* and *b*", "<i>a</i> and <i>b</i>"},
+		{"***both***", "<i><b>both</b></i>"},
+		{"__b__ and _i_", "<b>b</b> and <i>i</i>"},
+		{"snake_case_word", "snake_case_word"},
+		{"an _unclosed emphasis", "an _unclosed emphasis"},
+	}
+	for _, tt := range tests {
+		if got := convertMarkdownToHTML(tt.in); got != tt.want {
+			t.Errorf("convertMarkdownToHTML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Identifiers, paths and URLs in code-heavy replies keep their underscores
+// and asterisks
+func TestConvertMarkdownCodeHeavy(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{
+			"Rename `max_retry_count` to retry_limit in config_file.yaml.",
+			"Rename <code>max_retry_count</code> to retry_limit in config_file.yaml.",
+		},
+		{
+			"The log is in /var/log/my_app/error_log_file.txt by default.",
+			"The log is in /var/log/my_app/error_log_file.txt by default.",
+		},
+		{
+			"See https://example.com/docs/get_user_by_id?include_deleted=true and _this_ note.",
+			"See https://example.com/docs/get_user_by_id?include_deleted=true and <i>this</i> note.",
+		},
+		{
+			"Use `a * b * c` or `**kwargs` here.",
+			"Use <code>a * b * c</code> or <code>**kwargs</code> here.",
+		},
+		{
+			"```python\ndef total_price(unit_price, item_count):\n    return unit_price * item_count * TAX_RATE\n```",
+			"<code>def total_price(unit_price, item_count):\n    return unit_price * item_count * TAX_RATE\n</code>",
+		},
+		{
+			"It reads self.__private_attr and obj._cache_key, then *done*.",
+			"It reads self.__private_attr and obj._cache_key, then <i>done</i>.",
+		},
+	}
+	for _, tt := range tests {
+		if got := convertMarkdownToHTML(tt.in); got != tt.want {
+			t.Errorf("convertMarkdownToHTML(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Tables become aligned monospace text in <pre>, with the text around them
+// formatted as usual
+func TestConvertMarkdownTables(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "two columns",
+			in: "Results:\n" +
+				"| Name | Score |\n" +
+				"|------|------:|\n" +
+				"| Ann | 9 |\n" +
+				"| **Bob** | 10 |\n" +
+				"Done.",
+			want: "Results:\n<pre>" +
+				"Name | Score\n" +
+				"-----+------\n" +
+				"Ann  |     9\n" +
+				"Bob  |    10" +
+				"</pre>\nDone.",
+		},
+		{
+			name: "four columns with every alignment",
+			in: "| Op | Example | Result | Note |\n" +
+				"|:---|:-------:|-------:|------|\n" +
+				"| or | `a|b` | 7 | bitwise |\n" +
+				"| pipe | `ls | wc` | - | shell |\n" +
+				"| escaped | x \\| y | 1 | <ok> |",
+			want: "<pre>" +
+				"Op      | Example | Result | Note\n" +
+				"--------+---------+--------+--------\n" +
+				"or      |   a|b   |      7 | bitwise\n" +
+				"pipe    | ls | wc |      - | shell\n" +
+				"escaped |  x | y  |      1 | &lt;ok&gt;" +
+				"</pre>",
+		},
+		{
+			name: "ragged rows without a separator",
+			in:   "| a | b |\n| longer |\n| c | d | e |",
+			want: "<pre>" +
+				"a      | b |\n" +
+				"longer |   |\n" +
+				"c      | d | e" +
+				"</pre>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertMarkdownToHTML(tt.in); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+var (
+	outputTag       = regexp.MustCompile(`<(/?)([^\s>/]*)([^>]*)>`)
+	outputAttribute = regexp.MustCompile(`\s+([a-zA-Z-]+)="[^"<>]*"`)
+)
+
+// Attributes each allowed tag may carry in the output
+var allowedAttributes = map[string][]string{
+	"a": {"href"}, "code": {"class"}, "pre": nil, "b": nil, "i": nil, "s": nil, "blockquote": nil,
+}
+
+// Whatever the model sends, the output only has tags and attributes
+// Telegram's HTML mode accepts; anything else makes the message fail to send
+func TestConvertMarkdownOnlyTelegramTags(t *testing.T) {
+	corpus := []string{
+		"# Title\n## Subtitle\n###### Deep",
+		"<h1>Raw heading</h1><p>para</p><div>block</div>",
+		"<script>alert(1)</script><style>b{}</style>",
+		"<img src=x onerror=alert(1)><br><hr/>",
+		"<b onclick=\"x()\">bold</b> <a href=\"javascript:alert(1)\">x</a>",
+		"[click](\" onmouseover=\"alert(1))",
+		"[a](<u>) and [b](https://example.com/\"><script>)",
+		"**<b>** __<u>__ *<i>* ~~<s>~~ `<code>`",
+		"```html\n<html><body onload=x></body></html>\n```",
+		"> <blockquote>quoted</blockquote>",
+		"| <th> | <td> |\n|---|---|\n| <tr> | </table> |",
+		"a < b > c && d &lt;e&gt; &#60;f&#62;",
+		"<<b>>nested<</b>> <!-- comment --> <![CDATA[x]]> <?php ?>",
+		"- item <li>\n1. step <ol>",
+		"<tg-spoiler>ok</tg-spoiler> <span class=\"tg-spoiler\">ok</span>",
+	}
+	for _, in := range corpus {
+		out := convertMarkdownToHTML(in)
+		tags := outputTag.FindAllStringSubmatchIndex(out, -1)
+		if n := strings.Count(out, "<"); n != len(tags) {
+			t.Errorf("convertMarkdownToHTML(%q) = %q: %d unescaped < but %d tags", in, out, n, len(tags))
+		}
+		for _, m := range tags {
+			closing, name, attrs := out[m[2]:m[3]] == "/", out[m[4]:m[5]], out[m[6]:m[7]]
+			allowed, ok := allowedAttributes[name]
+			if !ok {
+				t.Errorf("convertMarkdownToHTML(%q) = %q: tag <%s> isn't allowed", in, out, name)
+				continue
+			}
+			if closing && attrs != "" {
+				t.Errorf("convertMarkdownToHTML(%q) = %q: closing tag </%s> has attributes", in, out, name)
+			}
+			for _, a := range outputAttribute.FindAllStringSubmatch(attrs, -1) {
+				if !slices.Contains(allowed, a[1]) {
+					t.Errorf("convertMarkdownToHTML(%q) = %q: <%s> has attribute %s", in, out, name, a[1])
+				}
+			}
+			if rest := outputAttribute.ReplaceAllString(attrs, ""); strings.TrimSpace(rest) != "" {
+				t.Errorf("convertMarkdownToHTML(%q) = %q: <%s> has malformed attributes %q", in, out, name, rest)
+			}
+		}
+	}
+}
+
+// Tags and entities don't count against Telegram's limit, so heavily marked
+// up text is measured and split by what's shown
+func TestSplitHTMLCountsVisibleText(t *testing.T) {
+	line := "<b>bold</b> <i>it</i> <code>x &lt; y</code> <a href=\"https://example.com/a/long/path\">link</a>\n"
+	short := strings.Repeat(line, 200)
+	if n := visibleLen(short); n != 3800 {
+		t.Fatalf("visibleLen = %d, want 3800", n)
+	}
+	if len(short) <= maxMessageLen() {
+		t.Fatalf("test HTML is only %d bytes, want it over the limit", len(short))
+	}
+	if chunks := splitHTML(short, maxMessageLen()); len(chunks) != 1 {
+		t.Errorf("split into %d chunks, want 1 since only %d characters show", len(chunks), visibleLen(short))
+	}
+
+	long := strings.Repeat(line, 700)
+	chunks := splitHTML(long, maxMessageLen())
+	if len(chunks) != 4 {
+		t.Errorf("split into %d chunks, want 4 for %d visible characters", len(chunks), visibleLen(long))
+	}
+	for i, chunk := range chunks {
+		if n := visibleLen(chunk); n > maxMessageLen() {
+			t.Errorf("chunk %d shows %d characters, over the limit of %d", i, n, maxMessageLen())
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/telebot.v3"
+)
+
+// chatMaxTokens returns the answer length limit for a chat: its own from
+// /maxtokens, capped at max_tokens, or max_tokens when it hasn't set one
+func chatMaxTokens(state *UserState) int {
+	ceiling := getMaxTokens()
+	if state.MaxTokens > 0 && state.MaxTokens < ceiling {
+		return state.MaxTokens
+	}
+	return ceiling
+}
+
+// handleMaxTokens implements /maxtokens <n>, with 0 going back to the default
+func handleMaxTokens(c telebot.Context) error {
+	state := loadUserState(c.Chat().ID)
+	cacheState(c.Chat().ID, state)
+	ceiling := getMaxTokens()
+	usage := fmt.Sprintf("Usage: /maxtokens <n> - limit answers to n tokens (up to %d)\n/maxtokens 0 - use the default", ceiling)
+	args := c.Args()
+
+	if len(args) == 0 {
+		current := fmt.Sprintf("%d (default)", ceiling)
+		if state.MaxTokens > 0 {
+			current = strconv.Itoa(chatMaxTokens(state))
+		}
+		return c.Send("Max tokens: " + current + "\n\n" + usage)
+	}
+	n, err := strconv.Atoi(args[0])
+	if len(args) != 1 || err != nil || n < 0 {
+		return c.Send(usage)
+	}
+	if n > ceiling {
+		return c.Send(fmt.Sprintf("The most this bot allows is %d tokens.", ceiling))
+	}
+
+	state.MaxTokens = n
+	saveUserState(c.Chat().ID, state)
+	if n == 0 {
+		return c.Send(fmt.Sprintf("Max tokens reset to the default, %d.", ceiling))
+	}
+	return c.Send(fmt.Sprintf("Answers are now limited to %d tokens. Longer ones are cut off.", n))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, served on /metrics when metrics_addr is configured
+var (
+	messagesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "telegram_llm_bot_messages_received_total",
+		Help: "Chat messages taken off the per-user queues for processing.",
+	})
+	apiCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_llm_bot_api_calls_total",
+		Help: "Requests made to the LLM API, by endpoint.",
+	}, []string{"endpoint"})
+	apiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_llm_bot_api_errors_total",
+		Help: "Failed requests to the LLM API (transport errors and non-2xx statuses), by endpoint.",
+	}, []string{"endpoint"})
+	tokensConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_llm_bot_tokens_total",
+		Help: "Tokens reported by the LLM API, by type (prompt or completion).",
+	}, []string{"type"})
+	apiLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "telegram_llm_bot_api_request_duration_seconds",
+		Help:    "Time until the LLM API responded, by endpoint. For streamed replies this is time to first byte.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"endpoint"})
+	apiRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "telegram_llm_bot_api_requests_in_flight",
+		Help: "Chat requests currently being answered by the LLM API, across all chats.",
+	})
+	apiRequestsWaiting = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "telegram_llm_bot_api_requests_waiting",
+		Help: "Chat requests waiting for a free slot under max_concurrent_requests.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(messagesReceived, apiCalls, apiErrors, tokensConsumed, apiLatency, apiRequestsInFlight, apiRequestsWaiting)
+}
+
+// observeAPICall records a finished API request for the given endpoint
+func observeAPICall(endpoint string, start time.Time, failed bool) {
+	apiCalls.WithLabelValues(endpoint).Inc()
+	apiLatency.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if failed {
+		apiErrors.WithLabelValues(endpoint).Inc()
+	}
+}
+
+// startMetricsServer serves /metrics on addr in the background
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		logger.Info("metrics server listening", slog.String("addr", addr))
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error("metrics server failed", slog.Any("error", err))
+		}
+	}()
+}
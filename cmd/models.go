@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// Inline buttons for picking a model. applyModelBtn carries the model id;
+// pickModelBtn carries an index into the model list for ids too long to fit
+// in callback data. modelsPageBtn flips /models pages.
+var (
+	applyModelBtn = telebot.Btn{Unique: "apply_model"}
+	pickModelBtn  = telebot.Btn{Unique: "pick_model"}
+	modelsPageBtn = telebot.Btn{Unique: "models_page"}
+)
+
+// Telegram limits callback data to 64 bytes, including the "\f<unique>|" prefix
+const maxCallbackData = 64
+
+// Models shown per /models page
+const modelsPerPage = 10
+
+// modelButton builds a button that switches to model. The id goes in the
+// callback data when it fits; otherwise its index in the cached list is used
+// (pass -1 if the model isn't from the list, in which case ok is false).
+func modelButton(markup *telebot.ReplyMarkup, label, model string, index int) (telebot.Btn, bool) {
+	if len("\f"+applyModelBtn.Unique+"|"+model) <= maxCallbackData {
+		return markup.Data(label, applyModelBtn.Unique, model), true
+	}
+	if index < 0 {
+		return telebot.Btn{}, false
+	}
+	return markup.Data(label, pickModelBtn.Unique, strconv.Itoa(index)), true
+}
+
+// modelsPage renders one page of the model list with a button per model and
+// Prev/Next navigation
+func modelsPage(models []string, page int) (string, *telebot.ReplyMarkup) {
+	pages := (len(models) + modelsPerPage - 1) / modelsPerPage
+	if page < 0 {
+		page = 0
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+
+	markup := &telebot.ReplyMarkup{}
+	var rows []telebot.Row
+	start := page * modelsPerPage
+	end := start + modelsPerPage
+	if end > len(models) {
+		end = len(models)
+	}
+	for i := start; i < end; i++ {
+		if btn, ok := modelButton(markup, models[i], models[i], i); ok {
+			rows = append(rows, markup.Row(btn))
+		}
+	}
+
+	var nav []telebot.Btn
+	if page > 0 {
+		nav = append(nav, markup.Data("« Prev", modelsPageBtn.Unique, strconv.Itoa(page-1)))
+	}
+	if page < pages-1 {
+		nav = append(nav, markup.Data("Next »", modelsPageBtn.Unique, strconv.Itoa(page+1)))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, markup.Row(nav...))
+	}
+	markup.Inline(rows...)
+
+	text := fmt.Sprintf("Available models (%d total, page %d/%d). Tap one to use it.", len(models), page+1, pages)
+	return text, markup
+}
+
+// handleModels implements /models; "/models refresh" skips the cache
+func handleModels(c telebot.Context) error {
+	fetch := fetchModels
+	if c.Message().Payload == "refresh" {
+		fetch = refreshModels
+	}
+	models, err := fetch()
+	if err != nil {
+		return c.Send("Failed to fetch models: " + err.Error())
+	}
+	if len(models) == 0 {
+		return c.Send("Could not parse models from API")
+	}
+	text, markup := modelsPage(models, 0)
+	return c.Send(text, markup)
+}
+
+// handlePing implements /ping: it times a fresh /models request to show
+// whether the API is reachable
+func handlePing(c telebot.Context) error {
+	bot.Notify(c.Chat(), telebot.Typing)
+	start := time.Now()
+	models, err := refreshModels()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		return c.Send(fmt.Sprintf("API unreachable (after %s): %v", elapsed, err))
+	}
+	return c.Send(fmt.Sprintf("Pong! The API answered in %s and lists %d models.", elapsed, len(models)))
+}
+
+// handleModelsPage flips the /models message to another page
+func handleModelsPage(c telebot.Context) error {
+	page, _ := strconv.Atoi(c.Callback().Data)
+	models, err := fetchModels()
+	if err != nil || len(models) == 0 {
+		return c.Respond(&telebot.CallbackResponse{Text: "Failed to fetch models"})
+	}
+	c.Respond()
+	text, markup := modelsPage(models, page)
+	return c.Edit(text, markup)
+}
+
+// handleApplyModel switches the chat to the model on the tapped button
+func handleApplyModel(c telebot.Context) error {
+	return setModelFromButton(c, c.Callback().Data)
+}
+
+// handlePickModel switches the chat to the model at the button's list index
+func handlePickModel(c telebot.Context) error {
+	index, err := strconv.Atoi(c.Callback().Data)
+	models, fetchErr := fetchModels()
+	if err != nil || fetchErr != nil || index < 0 || index >= len(models) {
+		return c.Respond(&telebot.CallbackResponse{Text: "That list is out of date, run /models again"})
+	}
+	return setModelFromButton(c, models[index])
+}
+
+// setModel switches the chat to a typed model name, warning if the provider
+// doesn't list it
+func setModel(c telebot.Context, state *UserState, model string) error {
+	state.Model = model
+	state.PendingInput = ""
+	state.ActiveSetup = ""
+	saveUserState(c.Chat().ID, state)
+	warning := modelWarning(model)
+	if state.LockedModel != "" {
+		return c.Send("Model set to: " + model + warning + "\nNote: this chat is locked to " + state.LockedModel + " until an admin runs /unlockmodel.")
+	}
+	return c.Send("Model set to: " + model + warning)
+}
+
+func setModelFromButton(c telebot.Context, model string) error {
+	if refusal := modelRefusal(c.Sender().ID, model); refusal != "" {
+		c.Respond()
+		return c.Send(refusal)
+	}
+	state := loadUserState(c.Chat().ID)
+	state.Model = model
+	state.ActiveSetup = ""
+	saveUserState(c.Chat().ID, state)
+	cacheState(c.Chat().ID, state)
+
+	c.Respond(&telebot.CallbackResponse{Text: "Model set to " + model})
+	if state.LockedModel != "" {
+		return c.Send("Model set to: " + model + "\nNote: this chat is locked to " + state.LockedModel + " until an admin runs /unlockmodel.")
+	}
+	return c.Send("Model set to: " + model)
+}
+
+// modelPatterns returns the model globs user_models permits for a user: the
+// entry for their ID, else the one for their role ("admin" or "reader"), else
+// "default". nil means the user may pick any model.
+func modelPatterns(userID int64) []string {
+	restrictions := viper.GetStringMapStringSlice("user_models")
+	for _, key := range []string{strconv.FormatInt(userID, 10), userRole(userID).String(), "default"} {
+		if patterns, ok := restrictions[key]; ok {
+			return patterns
+		}
+	}
+	return nil
+}
+
+// modelRefusal returns why a user may not select model, or "" if they may
+func modelRefusal(userID int64, model string) string {
+	patterns := modelPatterns(userID)
+	if patterns == nil {
+		return ""
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, model); ok {
+			return ""
+		}
+	}
+	if len(patterns) == 0 {
+		return "You aren't allowed to change the model."
+	}
+	return "You can't use " + model + ". Allowed models: " + strings.Join(patterns, ", ")
+}
+
+// modelWarning returns a note to append when model isn't in the provider's
+// list, suggesting the closest listed name. The model is still used either
+// way since some endpoints don't list everything. Returns "" if the model is
+// listed or the list can't be fetched.
+func modelWarning(model string) string {
+	models, err := fetchModels()
+	if err != nil || len(models) == 0 {
+		return ""
+	}
+	closest, best := "", -1
+	for _, m := range models {
+		if m == model {
+			return ""
+		}
+		if d := levenshtein(strings.ToLower(model), strings.ToLower(m)); best < 0 || d < best {
+			closest, best = m, d
+		}
+	}
+	warning := "\nWarning: " + model + " isn't in the provider's model list."
+	if best <= len([]rune(model))/2 {
+		warning += " Did you mean " + closest + "?"
+	}
+	return warning
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+// SamplingParams are optional sampling settings set with /params or a
+// preset. Unset fields are left out of the request so the model's defaults
+// apply.
+type SamplingParams struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+}
+
+// samplingParam describes one /params setting and its allowed range
+type samplingParam struct {
+	name     string
+	min, max float64
+	field    func(p *SamplingParams) **float64
+}
+
+var samplingParamList = []samplingParam{
+	{"temperature", 0, 2, func(p *SamplingParams) **float64 { return &p.Temperature }},
+	{"top_p", 0, 1, func(p *SamplingParams) **float64 { return &p.TopP }},
+	{"frequency_penalty", -2, 2, func(p *SamplingParams) **float64 { return &p.FrequencyPenalty }},
+	{"presence_penalty", -2, 2, func(p *SamplingParams) **float64 { return &p.PresencePenalty }},
+}
+
+func findSamplingParam(name string) (samplingParam, bool) {
+	for _, sp := range samplingParamList {
+		if sp.name == name {
+			return sp, true
+		}
+	}
+	return samplingParam{}, false
+}
+
+// parse reads a value for the setting, checking its range
+func (sp samplingParam) parse(value string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < sp.min || v > sp.max {
+		return 0, fmt.Errorf("%s must be a number from %g to %g", sp.name, sp.min, sp.max)
+	}
+	return v, nil
+}
+
+// parseParamAssignment reads a "name=value" argument such as temperature=0.7
+// into p. ok is false if arg isn't an assignment to a known setting.
+func parseParamAssignment(p *SamplingParams, arg string) (ok bool, err error) {
+	name, value, found := strings.Cut(arg, "=")
+	if !found {
+		return false, nil
+	}
+	sp, known := findSamplingParam(strings.ToLower(name))
+	if !known {
+		return false, nil
+	}
+	v, err := sp.parse(value)
+	if err != nil {
+		return true, err
+	}
+	*sp.field(p) = &v
+	return true, nil
+}
+
+// paramsSummary lists the settings that are set, e.g. "temperature=0.2
+// top_p=0.9", or "" if none are
+func paramsSummary(p SamplingParams) string {
+	var set []string
+	for _, sp := range samplingParamList {
+		if v := *sp.field(&p); v != nil {
+			set = append(set, sp.name+"="+strconv.FormatFloat(*v, 'g', -1, 64))
+		}
+	}
+	return strings.Join(set, " ")
+}
+
+// formatParams lists each setting's value, or "default" when unset
+func formatParams(p SamplingParams) string {
+	var b strings.Builder
+	for _, sp := range samplingParamList {
+		value := "default"
+		if v := *sp.field(&p); v != nil {
+			value = strconv.FormatFloat(*v, 'g', -1, 64)
+		}
+		fmt.Fprintf(&b, "%s: %s\n", sp.name, value)
+	}
+	return b.String()
+}
+
+const paramsUsage = "Usage:\n/params - show settings\n/params <name> <value> - set one (temperature 0 to 2, top_p 0 to 1, frequency_penalty and presence_penalty -2 to 2)\n/params <name> default - unset one\n/params reset - unset all"
+
+// handleParams implements /params
+func handleParams(c telebot.Context) error {
+	state := loadUserState(c.Chat().ID)
+	cacheState(c.Chat().ID, state)
+	args := c.Args()
+
+	if len(args) == 0 {
+		return c.Send("Sampling parameters\n\n" + formatParams(state.Params) + "\n" + paramsUsage)
+	}
+	if len(args) == 1 && args[0] == "reset" {
+		state.Params = SamplingParams{}
+		saveUserState(c.Chat().ID, state)
+		return c.Send("All sampling parameters reset to the model's defaults.")
+	}
+	if len(args) != 2 {
+		return c.Send(paramsUsage)
+	}
+
+	sp, ok := findSamplingParam(strings.ToLower(args[0]))
+	if !ok {
+		return c.Send("Unknown parameter " + args[0] + ".\n\n" + paramsUsage)
+	}
+	field := sp.field(&state.Params)
+	if args[1] == "default" {
+		*field = nil
+		saveUserState(c.Chat().ID, state)
+		return c.Send(sp.name + " reset to the model's default.")
+	}
+	v, err := sp.parse(args[1])
+	if err != nil {
+		return c.Send(err.Error() + ".")
+	}
+	*field = &v
+	saveUserState(c.Chat().ID, state)
+	return c.Send(fmt.Sprintf("%s set to %g.", sp.name, v))
+}
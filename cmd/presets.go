@@ -0,0 +1,90 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+// presetShortcut matches the /<n> commands the /preset listing advertises
+var presetShortcut = regexp.MustCompile(`^/(\d+)(@\w+)?$`)
+
+// Words that can't be preset names: /preset's own subcommands and the bot's
+// commands, which would make "/preset <name>" or the listing ambiguous
+var reservedPresetNames = []string{
+	"list", "help",
+	"start", "status", "params", "summarize", "batch", "oneshot", "history",
+	"model", "models", "recommend", "lockmodel", "unlockmodel", "quota",
+	"loglevel", "testallow", "broadcast", "system", "reset", "clear", "undo",
+	"usage", "chain", "stop", "new", "set", "preset", "export", "retry", "compare", "whoami", "clearfile", "private", "ping",
+	"save", "load", "conversations", "effort", "forget", "role", "roles", "feedback", "json", "seed", "maxtokens",
+}
+
+// checkPresetName returns why name can't be used for a preset, or "" if it
+// can. Names are any word without a slash; numbers are loaded with /<n>.
+func checkPresetName(name string) string {
+	if strings.Contains(name, "/") {
+		return "Preset names can't contain a slash."
+	}
+	for _, reserved := range reservedPresetNames {
+		if strings.EqualFold(name, reserved) {
+			return name + " is a command name, pick another preset name."
+		}
+	}
+	return ""
+}
+
+// presetNames returns the saved preset names, numbered slots first in
+// numeric order, then names alphabetically
+func presetNames(presets map[string]Preset) []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, aErr := strconv.Atoi(names[i])
+		b, bErr := strconv.Atoi(names[j])
+		switch {
+		case aErr == nil && bErr == nil:
+			return a < b
+		case aErr == nil || bErr == nil:
+			return aErr == nil
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// presetCommand is how the listing tells users to load a preset
+func presetCommand(name string) string {
+	if presetShortcut.MatchString("/" + name) {
+		return "/" + name
+	}
+	return "/preset " + name
+}
+
+// loadPreset switches the chat to the preset saved in slot
+func loadPreset(c telebot.Context, slot string) error {
+	state := loadUserState(c.Chat().ID)
+	preset, ok := state.Presets[slot]
+	if !ok {
+		return c.Send("Preset " + slot + " not found. Use /set to create one.")
+	}
+	if refusal := modelRefusal(c.Sender().ID, preset.Model); refusal != "" {
+		return c.Send(refusal)
+	}
+	state.Model = preset.Model
+	state.SystemPrompt = preset.SystemPrompt
+	state.Params = preset.Params
+	state.ActiveSetup = "preset " + slot
+	saveUserState(c.Chat().ID, state)
+	cacheState(c.Chat().ID, state)
+	msg := "Switched to preset " + slot + ":\nModel: " + preset.Model + "\nSystem: " + preset.SystemPrompt
+	if summary := paramsSummary(preset.Params); summary != "" {
+		msg += "\nSettings: " + summary
+	}
+	return c.Send(msg)
+}
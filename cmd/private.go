@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+// Private sessions are forgotten after this long without a message
+const privateSessionIdle = 30 * time.Minute
+
+// privateSession is the conversation content of a chat in private mode,
+// which is kept in memory only and never written to the state file
+type privateSession struct {
+	History        []ChatMessage
+	ModelHistories map[string][]ChatMessage
+	Summary        string
+	Title          string
+	Attachment     *Attachment
+	PendingImport  *ConversationExport
+	lastUsed       time.Time
+}
+
+var (
+	privateMu       sync.Mutex
+	privateSessions = make(map[int64]*privateSession)
+)
+
+// stashPrivate moves a private chat's conversation content from state into
+// memory, leaving state fit to be written to disk
+func stashPrivate(chatID int64, state *UserState) {
+	privateMu.Lock()
+	privateSessions[chatID] = &privateSession{
+		History:        state.History,
+		ModelHistories: state.ModelHistories,
+		Summary:        state.Summary,
+		Title:          state.Title,
+		Attachment:     state.Attachment,
+		PendingImport:  state.PendingImport,
+		lastUsed:       time.Now(),
+	}
+	privateMu.Unlock()
+
+	state.History = nil
+	state.ModelHistories = nil
+	state.Summary = ""
+	state.Title = ""
+	state.Attachment = nil
+	state.PendingImport = nil
+}
+
+// restorePrivate fills a private chat's loaded state with the content kept
+// in memory. Content saved before private mode was turned on is kept until
+// the next save moves it to memory.
+func restorePrivate(chatID int64, state *UserState) {
+	privateMu.Lock()
+	defer privateMu.Unlock()
+	session, ok := privateSessions[chatID]
+	if !ok {
+		return
+	}
+	session.lastUsed = time.Now()
+	state.History = session.History
+	state.ModelHistories = session.ModelHistories
+	state.Summary = session.Summary
+	state.Title = session.Title
+	state.Attachment = session.Attachment
+	state.PendingImport = session.PendingImport
+}
+
+// forgetPrivate drops a chat's private conversation, for a fresh start
+func forgetPrivate(chatID int64) {
+	privateMu.Lock()
+	delete(privateSessions, chatID)
+	privateMu.Unlock()
+}
+
+// evictPrivateSessions forgets private conversations that have been idle for
+// privateSessionIdle
+func evictPrivateSessions() {
+	privateMu.Lock()
+	defer privateMu.Unlock()
+	for chatID, session := range privateSessions {
+		if time.Since(session.lastUsed) >= privateSessionIdle {
+			delete(privateSessions, chatID)
+		}
+	}
+}
+
+// handlePrivate implements /private on|off
+func handlePrivate(c telebot.Context) error {
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+	args := c.Args()
+	if len(args) < 1 || (args[0] != "on" && args[0] != "off") {
+		mode := "off"
+		if state.Private {
+			mode = "on"
+		}
+		return c.Send("Private mode is " + mode + ".\nUsage: /private on|off")
+	}
+	state.Private = args[0] == "on"
+	saveUserState(chatID, state)
+	if state.Private {
+		return c.Send("Private mode on: this conversation is kept in memory only and is forgotten after 30 minutes of inactivity or a restart.")
+	}
+	return c.Send("Private mode off: the conversation is saved to disk again.")
+}
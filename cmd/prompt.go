@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Placeholders available in system prompts, listed when /system asks for one
+const promptVariables = "{{date}}, {{time}}, {{username}}"
+
+// withSystemPrefix puts system_prefix in front of a system prompt. The
+// prefix is sent even when the prompt is empty.
+func withSystemPrefix(prompt string) string {
+	prefix := strings.TrimSpace(viper.GetString("system_prefix"))
+	switch {
+	case prefix == "":
+		return prompt
+	case prompt == "":
+		return prefix
+	}
+	return prefix + "\n\n" + prompt
+}
+
+// expandPlaceholders fills in {{date}}, {{time}}, {{username}} and any extra
+// variables in text. Other text in double braces, like a template the prompt
+// talks about, is left as it is.
+func expandPlaceholders(text, username string, extra map[string]string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+
+	now := time.Now()
+	vars := []string{
+		"{{date}}", now.Format("2006-01-02"),
+		"{{time}}", now.Format("15:04"),
+		"{{username}}", username,
+	}
+	for name, value := range extra {
+		vars = append(vars, "{{"+name+"}}", value)
+	}
+	return strings.NewReplacer(vars...).Replace(text)
+}
+
+// senderName is the name {{username}} expands to: the first name, falling
+// back to the @username
+func senderName(firstName, username string) string {
+	if firstName != "" {
+		return firstName
+	}
+	return username
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Known placeholders are filled in; anything else in braces, like a prompt
+// about Go templates or Jinja, is kept as written instead of failing
+func TestExpandPlaceholders(t *testing.T) {
+	today := time.Now().Format("2006-01-02")
+	tests := []struct {
+		text, want string
+	}{
+		{"Hi {{username}}, today is {{date}}.", "Hi Ada, today is " + today + "."},
+		{"Running {{model}}.", "Running gpt-test."},
+		{"Explain {{ .Name }} and {{range .Items}}.", "Explain {{ .Name }} and {{range .Items}}."},
+		{"Unclosed {{username and {{ stray", "Unclosed {{username and {{ stray"},
+		{"Jinja: {% if x %}{{ x }}{% endif %}, user {{username}}", "Jinja: {% if x %}{{ x }}{% endif %}, user Ada"},
+		{"No placeholders at all", "No placeholders at all"},
+	}
+	for _, tt := range tests {
+		got := expandPlaceholders(tt.text, "Ada", map[string]string{"model": "gpt-test"})
+		if got != tt.want {
+			t.Errorf("expandPlaceholders(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+	if got := expandPlaceholders("{{time}}", "Ada", nil); strings.Contains(got, "{") {
+		t.Errorf("{{time}} not filled in: %q", got)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// Default for max_queued_messages
+const defaultMaxQueuedMessages = 10
+
+// maxQueuedMessages returns max_queued_messages, the most messages that can
+// wait in a chat's queue
+func maxQueuedMessages() int {
+	if n := viper.GetInt("max_queued_messages"); n > 0 {
+		return n
+	}
+	return defaultMaxQueuedMessages
+}
+
+// Weight of the newest answer in a chat's average answer time, so the
+// average follows changes like a switch to a slower model within a few
+// answers
+const answerTimeWeight = 0.3
+
+// Rolling average time each chat's messages take to answer, for the wait
+// estimate when its queue is full
+var (
+	answerTimesMu sync.Mutex
+	answerTimes   = make(map[int64]time.Duration)
+)
+
+// recordAnswerTime adds how long a message took to answer to the chat's
+// average
+func recordAnswerTime(chatID int64, took time.Duration) {
+	answerTimesMu.Lock()
+	defer answerTimesMu.Unlock()
+	avg, ok := answerTimes[chatID]
+	if !ok {
+		answerTimes[chatID] = took
+		return
+	}
+	answerTimes[chatID] = time.Duration(answerTimeWeight*float64(took) + (1-answerTimeWeight)*float64(avg))
+}
+
+// estimatedWait guesses how long the messages ahead will take to answer,
+// from the chat's average answer time. ok is false before any were answered.
+func estimatedWait(chatID int64, ahead int) (wait time.Duration, ok bool) {
+	answerTimesMu.Lock()
+	avg, ok := answerTimes[chatID]
+	answerTimesMu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	return (time.Duration(ahead) * avg).Round(time.Second), true
+}
+
+// Messages held back while waiting to see if more follow (debounce_ms).
+// Guarded by mu.
+var debounced = make(map[int64]*debouncedMessage)
+
+type debouncedMessage struct {
+	queued queuedMessage
+	timer  *time.Timer
+}
+
+// debounceMessage holds a message for debounce_ms. Messages arriving within
+// the window are appended to it with newlines, and the window restarts; once
+// it passes quietly the combined message is queued. Without debounce_ms the
+// message is queued straight away.
+func debounceMessage(c telebot.Context, queued queuedMessage) error {
+	window := time.Duration(viper.GetInt("debounce_ms")) * time.Millisecond
+	if window <= 0 {
+		return enqueueMessage(c, queued)
+	}
+
+	// The context is only valid until the handler returns, so everything the
+	// flush needs is taken from it now
+	queued = fromSender(c, queued)
+	chatID := queued.Chat.ID
+	mu.Lock()
+	defer mu.Unlock()
+	if pending := debounced[chatID]; pending != nil {
+		pending.queued.Text += "\n" + queued.Text
+		pending.queued.MessageID = queued.MessageID
+		pending.timer.Reset(window)
+		return nil
+	}
+	pending := &debouncedMessage{queued: queued}
+	pending.timer = time.AfterFunc(window, func() { flushDebounced(chatID) })
+	debounced[chatID] = pending
+	return nil
+}
+
+// flushDebounced queues a chat's held-back message
+func flushDebounced(chatID int64) {
+	mu.Lock()
+	pending := debounced[chatID]
+	delete(debounced, chatID)
+	mu.Unlock()
+	if pending != nil {
+		if err := queueMessage(pending.queued); err != nil {
+			logger.Warn("failed to queue debounced message", slog.Int64("chat_id", chatID), slog.Any("error", err))
+		}
+	}
+}
+
+// queuePosition is where a just-queued message stands in line, counting the
+// one being answered
+func queuePosition(chatID int64, queue chan queuedMessage) int {
+	position := len(queue)
+	if requestActive(chatID) {
+		position++
+	}
+	return position
+}
+
+// mergeable reports whether a queued message is plain text that batching
+// can combine with others
+func mergeable(queued queuedMessage) bool {
+	return queued.Document == nil && queued.Model == "" && len(queued.Images) == 0
+}
+
+// mergeWaiting folds the messages already waiting behind first into one
+// prompt, returning it and how many messages it covers. A document, a photo
+// or a /retry can't be merged; it is returned to be answered next.
+func mergeWaiting(queue chan queuedMessage, first queuedMessage) (queuedMessage, *queuedMessage, int) {
+	if !mergeable(first) {
+		return first, nil, 1
+	}
+	count := 1
+	for {
+		select {
+		case next := <-queue:
+			if !mergeable(next) {
+				return first, &next, count
+			}
+			first.Text += "\n\n" + next.Text
+			first.MessageID = next.MessageID
+			count++
+		default:
+			return first, nil, count
+		}
+	}
+}
+
+// Queued messages are mirrored to data/store/queue_<id>.json, in queue
+// order, until they're answered so a restart doesn't lose them
+var (
+	queueStoreMu sync.Mutex
+	queueStore   = make(map[int64][]queuedMessage)
+)
+
+func getQueueFilePath(chatID int64) string {
+	return fmt.Sprintf("./data/store/queue_%d.json", chatID)
+}
+
+// saveQueueLocked writes a chat's pending messages, removing the file once
+// there are none. While any was sent in private mode they're only kept in
+// memory. queueStoreMu must be held.
+func saveQueueLocked(chatID int64) {
+	path := getQueueFilePath(chatID)
+	items := queueStore[chatID]
+	private := slices.ContainsFunc(items, func(q queuedMessage) bool { return q.Private })
+	if len(items) == 0 || private {
+		if len(items) == 0 {
+			delete(queueStore, chatID)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Error("failed to remove queue file", slog.String("path", path), slog.Any("error", err))
+		}
+		return
+	}
+	data, err := json.Marshal(items)
+	if err == nil {
+		data, err = sealData(data)
+	}
+	if err == nil {
+		err = writeFileAtomic(path, data, 0644)
+	}
+	if err != nil {
+		logger.Error("failed to save queue", slog.Int64("chat_id", chatID), slog.Any("error", err))
+	}
+}
+
+// forgetQueued drops the first n pending messages once they've been handled
+func forgetQueued(chatID int64, n int) {
+	queueStoreMu.Lock()
+	defer queueStoreMu.Unlock()
+	items := queueStore[chatID]
+	if n > len(items) {
+		n = len(items)
+	}
+	queueStore[chatID] = items[n:]
+	saveQueueLocked(chatID)
+}
+
+// restoreQueues requeues messages left over from the last run, restarting
+// each chat's worker. Messages that were already answered, by Telegram
+// message ID, are skipped.
+func restoreQueues() {
+	paths, err := filepath.Glob("./data/store/queue_*.json")
+	if err != nil {
+		logger.Error("failed to list queue files", slog.Any("error", err))
+		return
+	}
+	for _, path := range paths {
+		var chatID int64
+		if _, err := fmt.Sscanf(filepath.Base(path), "queue_%d.json", &chatID); err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err == nil {
+			data, _, err = openData(data)
+		}
+		if err != nil {
+			logger.Error("failed to read queue file", slog.String("path", path), slog.Any("error", err))
+			continue
+		}
+		var items []queuedMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			logger.Error("failed to parse queue file", slog.String("path", path), slog.Any("error", err))
+			continue
+		}
+
+		answered := loadUserState(chatID).AnsweredMessageID
+		var pending []queuedMessage
+		for _, item := range items {
+			if item.MessageID != 0 && item.MessageID <= answered {
+				continue
+			}
+			if len(pending) == maxQueuedMessages() {
+				logger.Warn("dropping restored message, queue full", slog.Int64("chat_id", chatID))
+				continue
+			}
+			pending = append(pending, item)
+		}
+
+		queueStoreMu.Lock()
+		queueStore[chatID] = pending
+		saveQueueLocked(chatID)
+		queueStoreMu.Unlock()
+		if len(pending) == 0 {
+			continue
+		}
+
+		queue := make(chan queuedMessage, maxQueuedMessages())
+		for _, item := range pending {
+			queue <- item
+		}
+		mu.Lock()
+		userQueues[chatID] = queue
+		mu.Unlock()
+		go processMessageQueue(chatID)
+		logger.Info("restored queued messages", slog.Int64("chat_id", chatID), slog.Int("count", len(pending)))
+	}
+}
+
+// handleBatch implements /batch on|off
+func handleBatch(c telebot.Context) error {
+	state := loadUserState(c.Chat().ID)
+	cacheState(c.Chat().ID, state)
+	args := c.Args()
+	if len(args) < 1 || (args[0] != "on" && args[0] != "off") {
+		mode := "off"
+		if state.Batch {
+			mode = "on"
+		}
+		return c.Send("Batch mode is " + mode + ".\nUsage: /batch on|off")
+	}
+	state.Batch = args[0] == "on"
+	saveUserState(c.Chat().ID, state)
+	if state.Batch {
+		return c.Send("Batch mode on: messages sent while I'm answering are combined into one prompt.")
+	}
+	return c.Send("Batch mode off: each message is answered separately.")
+}
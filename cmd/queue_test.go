@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// A chat's queue is saved to disk unless one of its messages was sent in
+// private mode, decided from the messages rather than the chat's state
+func TestSaveQueueSkipsPrivateMessages(t *testing.T) {
+	dir, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(dir)
+	os.MkdirAll("./data/store", 0755)
+
+	const chatID = 574
+	path := getQueueFilePath(chatID)
+	queueStoreMu.Lock()
+	defer queueStoreMu.Unlock()
+	defer delete(queueStore, chatID)
+
+	queueStore[chatID] = []queuedMessage{{Text: "hello", MessageID: 1}}
+	saveQueueLocked(chatID)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("queue not saved: %v", err)
+	}
+
+	queueStore[chatID] = append(queueStore[chatID], queuedMessage{Text: "secret", MessageID: 2, Private: true})
+	saveQueueLocked(chatID)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("queue file kept with a private message waiting: %v", err)
+	}
+}
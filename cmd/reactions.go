@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// Actions a reaction can trigger
+const (
+	reactionRegenerate = "regenerate" // Answer the latest message again, like /retry with the chat's model
+	reactionForget     = "forget"     // Remove the exchange from the history, like /forget
+)
+
+// defaultReactions map emoji to actions when reactions isn't set. Users can
+// only react with Telegram's standard reactions, which don't include 🔄 or
+// 🗑, so "think again" and "unsee that" stand in for them.
+var defaultReactions = map[string]string{
+	"🤔": reactionRegenerate,
+	"🙈": reactionForget,
+}
+
+// Updates requested while reactions are on. Telegram only sends
+// message_reaction when asked to, and asking replaces its default list.
+var reactionAllowedUpdates = []string{
+	"message", "edited_message", "channel_post", "edited_channel_post",
+	"inline_query", "chosen_inline_result", "callback_query", "my_chat_member",
+	"message_reaction",
+}
+
+// How long a getUpdates call waits for updates, and the pause after one fails
+const (
+	reactionPollTimeout = 25 * time.Second
+	reactionPollBackoff = 3 * time.Second
+)
+
+// reactionAction returns the action a reaction emoji is mapped to, or ""
+func reactionAction(emoji string) string {
+	if custom := viper.GetStringMapString("reactions"); len(custom) > 0 {
+		return custom[emoji]
+	}
+	return defaultReactions[emoji]
+}
+
+// messageReaction is a message_reaction update, which telebot v3.2.1 doesn't
+// know about
+type messageReaction struct {
+	Chat        telebot.Chat  `json:"chat"`
+	MessageID   int           `json:"message_id"`
+	User        *telebot.User `json:"user"` // Unset for anonymous group admins
+	OldReaction []reaction    `json:"old_reaction"`
+	NewReaction []reaction    `json:"new_reaction"`
+}
+
+type reaction struct {
+	Type  string `json:"type"` // "emoji" or "custom_emoji"
+	Emoji string `json:"emoji"`
+}
+
+// added returns the emoji reactions in the update that weren't there before
+func (r messageReaction) added() []string {
+	old := make(map[string]bool)
+	for _, o := range r.OldReaction {
+		old[o.Emoji] = true
+	}
+	var added []string
+	for _, n := range r.NewReaction {
+		if n.Type == "emoji" && !old[n.Emoji] {
+			added = append(added, n.Emoji)
+		}
+	}
+	return added
+}
+
+// reactionPoller long-polls like telebot.LongPoller, but also asks for
+// message_reaction updates and handles them itself, since telebot would drop
+// them. Everything else goes to the bot as usual.
+type reactionPoller struct {
+	lastUpdateID int
+}
+
+func (p *reactionPoller) Poll(b *telebot.Bot, dest chan telebot.Update, stop chan struct{}) {
+	allowed, _ := json.Marshal(reactionAllowedUpdates)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		data, err := b.Raw("getUpdates", map[string]string{
+			"offset":          strconv.Itoa(p.lastUpdateID + 1),
+			"timeout":         strconv.Itoa(int(reactionPollTimeout / time.Second)),
+			"allowed_updates": string(allowed),
+		})
+		var resp struct {
+			Result []struct {
+				telebot.Update
+				MessageReaction *messageReaction `json:"message_reaction"`
+			} `json:"result"`
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &resp)
+		}
+		if err != nil {
+			logger.Warn("failed to get updates", slog.Any("error", err))
+			time.Sleep(reactionPollBackoff)
+			continue
+		}
+
+		for _, u := range resp.Result {
+			p.lastUpdateID = u.ID
+			if u.MessageReaction != nil {
+				go handleReaction(*u.MessageReaction)
+				continue
+			}
+			dest <- u.Update
+		}
+	}
+}
+
+// handleReaction runs the action of each reaction newly added to one of the
+// bot's answers
+func handleReaction(r messageReaction) {
+	if r.User == nil || userRole(r.User.ID) == roleBlocked {
+		return
+	}
+	for _, emoji := range r.added() {
+		action := reactionAction(emoji)
+		if action == "" {
+			continue
+		}
+		log := logger.With(slog.Int64("chat_id", r.Chat.ID), slog.Int("message_id", r.MessageID), slog.String("action", action))
+		log.Debug("reaction received")
+		if err := runReaction(r, action); err != nil {
+			log.Warn("reaction failed", slog.Any("error", err))
+		}
+	}
+}
+
+// runReaction carries out a reaction's action on the answer it was added to
+func runReaction(r messageReaction, action string) error {
+	chat := &r.Chat
+	c := bot.NewContext(telebot.Update{Message: &telebot.Message{Chat: chat, Sender: r.User}})
+	state := loadUserState(chat.ID)
+	cacheState(chat.ID, state)
+	pos, ok := state.ReplyIndex[r.MessageID]
+	if !ok || pos < 1 || pos > len(state.History) {
+		// Not an answer in the current conversation
+		return nil
+	}
+
+	switch action {
+	case reactionRegenerate:
+		if commandDisabled("retry") {
+			return nil
+		}
+		if pos != len(state.History) {
+			return c.Send("Only the latest answer can be regenerated.")
+		}
+		return requeueLastPrompt(c, state, "")
+	case reactionForget:
+		if commandDisabled("forget") {
+			return nil
+		}
+		forgetMessage(state, pos-1)
+		saveUserState(chat.ID, state)
+		_, err := bot.Reply(&telebot.Message{ID: r.MessageID, Chat: chat}, "Removed this exchange from the conversation.")
+		return err
+	}
+	logger.Warn("unknown reaction action in config", slog.String("action", action))
+	return nil
+}
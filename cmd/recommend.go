@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+const recommendPrompt = "You help users pick an LLM. Given a task and a list of available model ids, " +
+	"recommend 2 or 3 models from the list that suit the task. Only use ids exactly as they appear in the list. " +
+	`Reply with JSON only, in the form [{"model": "<id>", "reason": "<one short sentence>"}].`
+
+// Recommendation is one suggested model with the reason for it
+type Recommendation struct {
+	Model  string `json:"model"`
+	Reason string `json:"reason"`
+}
+
+// utilityModel returns the model used for helper tasks like /recommend
+func utilityModel() string {
+	if model := viper.GetString("utility_model"); model != "" {
+		return model
+	}
+	return viper.GetString("default_model")
+}
+
+// recommendModels asks the utility model to pick suitable models for a task,
+// keeping only suggestions that are actually available
+func recommendModels(ctx context.Context, task string, models []string) ([]Recommendation, error) {
+	reply, err := complete(ctx, utilityModel(), []ChatMessage{
+		{Role: "system", Content: recommendPrompt},
+		{Role: "user", Content: "Task: " + task + "\n\nAvailable models:\n" + strings.Join(models, "\n")},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Models often wrap JSON in a code fence despite being told not to
+	reply = strings.TrimSpace(reply)
+	if start, end := strings.Index(reply, "["), strings.LastIndex(reply, "]"); start >= 0 && end > start {
+		reply = reply[start : end+1]
+	}
+
+	var suggestions []Recommendation
+	if err := json.Unmarshal([]byte(reply), &suggestions); err != nil {
+		logger.Warn("unparseable recommendation", slog.String("reply", reply), slog.Any("error", err))
+		return nil, errors.New("the model didn't return a usable recommendation")
+	}
+
+	available := make(map[string]bool, len(models))
+	for _, m := range models {
+		available[m] = true
+	}
+	var result []Recommendation
+	for _, s := range suggestions {
+		if available[s.Model] && len(result) < 3 {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// handleRecommend implements /recommend <task description>
+func handleRecommend(c telebot.Context) error {
+	task := strings.TrimSpace(c.Message().Payload)
+	if task == "" {
+		return c.Send("Usage: /recommend <task description>\nExample: /recommend refactoring a large Go codebase")
+	}
+
+	models, err := fetchModels()
+	if err != nil {
+		return c.Send("Failed to fetch models: " + err.Error())
+	}
+	if len(models) == 0 {
+		return c.Send("Could not parse models from API")
+	}
+
+	bot.Notify(c.Chat(), telebot.Typing)
+	ctx, done := beginRequest(c.Chat().ID)
+	suggestions, err := recommendModels(ctx, task, models)
+	done()
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return c.Send("Request cancelled.")
+		}
+		return c.Send("Failed to get a recommendation: " + err.Error())
+	}
+	if len(suggestions) == 0 {
+		return c.Send("No suitable models found in the available list.")
+	}
+
+	msg := "Suggested models:\n\n"
+	markup := &telebot.ReplyMarkup{}
+	var rows []telebot.Row
+	for i, s := range suggestions {
+		msg += fmt.Sprintf("%d. %s\n%s\n\n", i+1, s.Model, s.Reason)
+		if btn, ok := modelButton(markup, "Use "+s.Model, s.Model, -1); ok {
+			rows = append(rows, markup.Row(btn))
+		}
+	}
+	markup.Inline(rows...)
+	return c.Send(strings.TrimSpace(msg), markup)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+type requestLoggerKey struct{}
+
+// newRequestID returns a short random ID that tells one message's log lines
+// apart from another's
+func newRequestID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestLogger returns a context whose log lines go through l, so a
+// message can be followed from the queue to the API and back
+func withRequestLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, requestLoggerKey{}, l)
+}
+
+// requestLogger returns the logger for the request ctx belongs to, or the
+// package logger outside one
+func requestLogger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(requestLoggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return logger
+}
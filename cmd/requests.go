@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// In-flight requests per chat, so /stop can cancel them. A chat can have
+// several at once: /compare, /chain and the like run beside the queue worker.
+var (
+	requestsMu     sync.Mutex
+	activeRequests = make(map[int64][]*activeRequest)
+)
+
+type activeRequest struct {
+	cancel context.CancelFunc
+}
+
+// beginRequest returns a context for a chat's API request that /stop can
+// cancel. The returned func must be called once the request is finished.
+func beginRequest(chatID int64) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := &activeRequest{cancel: cancel}
+
+	requestsMu.Lock()
+	activeRequests[chatID] = append(activeRequests[chatID], req)
+	requestsMu.Unlock()
+
+	return ctx, func() {
+		requestsMu.Lock()
+		reqs := slices.DeleteFunc(activeRequests[chatID], func(r *activeRequest) bool { return r == req })
+		if len(reqs) == 0 {
+			delete(activeRequests, chatID)
+		} else {
+			activeRequests[chatID] = reqs
+		}
+		requestsMu.Unlock()
+		cancel()
+	}
+}
+
+// cancelRequest cancels all of the chat's in-flight requests, reporting
+// whether there were any
+func cancelRequest(chatID int64) bool {
+	requestsMu.Lock()
+	reqs := activeRequests[chatID]
+	delete(activeRequests, chatID)
+	requestsMu.Unlock()
+
+	for _, req := range reqs {
+		req.cancel()
+	}
+	return len(reqs) > 0
+}
+
+// requestActive reports whether the chat has a request in flight
+func requestActive(chatID int64) bool {
+	requestsMu.Lock()
+	defer requestsMu.Unlock()
+	return len(activeRequests[chatID]) > 0
+}
+
+// Slots for API requests across all chats, so max_concurrent_requests bounds
+// the load on the backend however many chats are busy. nil means no limit.
+var requestSlots chan struct{}
+
+// initRequestSlots applies max_concurrent_requests
+func initRequestSlots() {
+	if n := viper.GetInt("max_concurrent_requests"); n > 0 {
+		requestSlots = make(chan struct{}, n)
+	}
+}
+
+// acquireRequestSlot waits until an API request may start, or ctx is done.
+// The returned func must be called once the request is finished.
+func acquireRequestSlot(ctx context.Context) (func(), error) {
+	if requestSlots != nil {
+		apiRequestsWaiting.Inc()
+		select {
+		case requestSlots <- struct{}{}:
+			apiRequestsWaiting.Dec()
+		case <-ctx.Done():
+			apiRequestsWaiting.Dec()
+			return nil, ctx.Err()
+		}
+	}
+	apiRequestsInFlight.Inc()
+	return func() {
+		apiRequestsInFlight.Dec()
+		if requestSlots != nil {
+			<-requestSlots
+		}
+	}, nil
+}
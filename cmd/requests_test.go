@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+// A command's request starting beside the queued answer must not hide the
+// answer from /stop, and finishing it must not untrack the answer
+func TestConcurrentRequestsPerChat(t *testing.T) {
+	const chatID = -566
+	answer, answerDone := beginRequest(chatID)
+	defer answerDone()
+
+	_, compareDone := beginRequest(chatID)
+	compareDone()
+	if !requestActive(chatID) {
+		t.Fatal("requestActive = false after the second request finished, want the first still tracked")
+	}
+
+	compare, compareDone := beginRequest(chatID)
+	defer compareDone()
+	if !cancelRequest(chatID) {
+		t.Fatal("cancelRequest = false, want true")
+	}
+	if answer.Err() == nil {
+		t.Error("queued answer not cancelled by cancelRequest")
+	}
+	if compare.Err() == nil {
+		t.Error("command request not cancelled by cancelRequest")
+	}
+	if requestActive(chatID) {
+		t.Error("requestActive = true after cancelRequest, want false")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+
+	"gopkg.in/telebot.v3"
+)
+
+// handleRetry implements /retry <model>: the latest exchange is dropped and
+// its prompt is answered again by another model, without changing the chat's
+// model
+func handleRetry(c telebot.Context) error {
+	model := strings.TrimSpace(c.Message().Payload)
+	if model == "" {
+		return c.Send("Usage: /retry <model> - answer your last message again with another model")
+	}
+
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+	if state.LockedModel != "" {
+		return c.Send("This chat is locked to " + state.LockedModel + ", so other models can't be used.")
+	}
+	if refusal := modelRefusal(c.Sender().ID, model); refusal != "" {
+		return c.Send(refusal)
+	}
+	return requeueLastPrompt(c, state, model)
+}
+
+// requeueLastPrompt drops the latest exchange and queues its prompt to be
+// answered again, by model if it's set or else by the chat's model
+func requeueLastPrompt(c telebot.Context, state *UserState, model string) error {
+	chatID := c.Chat().ID
+	n := len(state.History)
+	if n < 2 || state.History[n-2].Role != "user" {
+		return c.Send("There's no previous message to retry.")
+	}
+	prompt, images := state.History[n-2].Content, state.History[n-2].Images
+	state.History = state.History[:n-2]
+	pruneReplyIndex(state)
+	saveUserState(chatID, state)
+
+	return enqueueMessage(c, queuedMessage{
+		Text:      prompt,
+		MessageID: state.LastMessageID,
+		Sender:    senderName(c.Sender().FirstName, c.Sender().Username),
+		Model:     model,
+		Images:    images,
+	})
+}
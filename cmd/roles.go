@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// Role is a read-only system prompt from the roles config that any chat can
+// switch to with /role. Unlike /set presets, roles are shared by everyone and
+// only change the system prompt.
+type Role struct {
+	Name         string `mapstructure:"name"`
+	Description  string `mapstructure:"description"`
+	SystemPrompt string `mapstructure:"system_prompt"`
+}
+
+// Roles from the config, read once at startup by loadRoles
+var roles []Role
+
+// loadRoles reads and checks the roles config
+func loadRoles() error {
+	var loaded []Role
+	if err := viper.UnmarshalKey("roles", &loaded); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, r := range loaded {
+		name := strings.ToLower(r.Name)
+		switch {
+		case name == "" || strings.ContainsAny(name, " \t\n"):
+			return fmt.Errorf("role names must be one word, got %q", r.Name)
+		case strings.TrimSpace(r.SystemPrompt) == "":
+			return fmt.Errorf("role %q has no system_prompt", r.Name)
+		case seen[name]:
+			return fmt.Errorf("role %q is defined twice", r.Name)
+		}
+		seen[name] = true
+	}
+	sort.Slice(loaded, func(i, j int) bool { return strings.ToLower(loaded[i].Name) < strings.ToLower(loaded[j].Name) })
+	roles = loaded
+	return nil
+}
+
+func findRole(name string) (Role, bool) {
+	for _, r := range roles {
+		if strings.EqualFold(r.Name, name) {
+			return r, true
+		}
+	}
+	return Role{}, false
+}
+
+// handleRoles implements /roles
+func handleRoles(c telebot.Context) error {
+	if len(roles) == 0 {
+		return c.Send("No roles configured.")
+	}
+	var b strings.Builder
+	b.WriteString("Available roles:\n\n")
+	for _, r := range roles {
+		b.WriteString("- " + r.Name)
+		if r.Description != "" {
+			b.WriteString(": " + r.Description)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\nUsage: /role <name>")
+	return c.Send(b.String())
+}
+
+// handleRole implements /role <name>: the role's prompt becomes the chat's
+// system prompt. Presets saved with /set are left alone.
+func handleRole(c telebot.Context) error {
+	name := strings.TrimSpace(c.Message().Payload)
+	if name == "" {
+		return handleRoles(c)
+	}
+	role, ok := findRole(name)
+	if !ok {
+		return c.Send(fmt.Sprintf("No role named %q. /roles lists them.", name))
+	}
+
+	state := loadUserState(c.Chat().ID)
+	cacheState(c.Chat().ID, state)
+	state.SystemPrompt = role.SystemPrompt
+	state.SystemEnabled = true
+	state.ActiveSetup = "role " + role.Name
+	saveUserState(c.Chat().ID, state)
+	return c.Send("Now using role " + role.Name + ":\n" + truncateText(role.SystemPrompt, 200))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/telebot.v3"
+)
+
+const seedUsage = "Usage: /seed <number> - sample with a fixed seed, so the same prompt gives the same answer more often\n/seed off - random sampling again"
+
+// handleSeed implements /seed
+func handleSeed(c telebot.Context) error {
+	state := loadUserState(c.Chat().ID)
+	cacheState(c.Chat().ID, state)
+	args := c.Args()
+
+	if len(args) == 0 {
+		current := "off"
+		if state.Seed != nil {
+			current = strconv.FormatInt(*state.Seed, 10)
+		}
+		return c.Send("Seed: " + current + "\n\n" + seedUsage)
+	}
+	if len(args) != 1 {
+		return c.Send(seedUsage)
+	}
+
+	if args[0] == "off" {
+		state.Seed = nil
+		saveUserState(c.Chat().ID, state)
+		return c.Send("Seed cleared.")
+	}
+	seed, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return c.Send(seedUsage)
+	}
+	state.Seed = &seed
+	saveUserState(c.Chat().ID, state)
+
+	msg := fmt.Sprintf("Seed set to %d. Whether answers repeat depends on the backend: some ignore the seed, and even those that honor it only make a best effort.", seed)
+	if t := state.Params.Temperature; t == nil || *t != 0 {
+		msg += " For the most reproducible answers, also use /params temperature 0."
+	}
+	return c.Send(msg)
+}
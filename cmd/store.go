@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// unsavedState is the latest state file content of a chat whose state
+// couldn't be written to data/store. It stands in for the file until a save
+// succeeds, so the chat keeps working from memory instead of losing each
+// change on the next load.
+type unsavedState struct {
+	data     []byte
+	notified bool // The chat was told its settings aren't being saved
+}
+
+var (
+	unsavedMu     sync.Mutex
+	unsavedStates = make(map[int64]*unsavedState)
+)
+
+// Sent once to a chat whose state can't be saved
+const unsavedNotice = "I can't save your settings and conversation right now, so they're only kept in memory and will be lost if I restart."
+
+// keepUnsaved holds a chat's state file content in memory after a failed
+// write, telling the chat once unless notify_store_failures is false
+func keepUnsaved(chatID int64, data []byte) {
+	unsavedMu.Lock()
+	entry, ok := unsavedStates[chatID]
+	if !ok {
+		entry = &unsavedState{}
+		unsavedStates[chatID] = entry
+	}
+	entry.data = data
+	notify := !entry.notified && bot != nil && (!viper.IsSet("notify_store_failures") || viper.GetBool("notify_store_failures"))
+	if notify {
+		entry.notified = true
+	}
+	unsavedMu.Unlock()
+
+	if notify {
+		if _, err := bot.Send(&telebot.Chat{ID: chatID}, unsavedNotice); err != nil {
+			logger.Warn("failed to send unsaved state notice", slog.Int64("chat_id", chatID), slog.Any("error", err))
+		}
+	}
+}
+
+// forgetUnsaved drops a chat's in-memory state, once a write succeeds or
+// the state is deleted. Reports whether there was one.
+func forgetUnsaved(chatID int64) bool {
+	unsavedMu.Lock()
+	defer unsavedMu.Unlock()
+	_, ok := unsavedStates[chatID]
+	delete(unsavedStates, chatID)
+	return ok
+}
+
+// unsavedData returns a chat's state file content held in memory, if a
+// write of it failed
+func unsavedData(chatID int64) ([]byte, bool) {
+	unsavedMu.Lock()
+	defer unsavedMu.Unlock()
+	entry, ok := unsavedStates[chatID]
+	if !ok {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+// checkStoreWritable tries writing a file to data/store
+func checkStoreWritable() error {
+	f, err := os.CreateTemp("./data/store", ".write-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// Default minimum time between live edits of a streamed reply (Telegram
+// rate-limits edits)
+const defaultStreamEditInterval = time.Second
+
+// streamEditInterval returns stream_edit_interval_ms as a duration
+func streamEditInterval() time.Duration {
+	if ms := viper.GetInt("stream_edit_interval_ms"); ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return defaultStreamEditInterval
+}
+
+// Streaming API types. Type, Delta, Error and Message are only set in
+// Anthropic stream events.
+type ChatStreamChunk struct {
+	Choices []StreamChoice `json:"choices"`
+	Type    string         `json:"type"`
+	Delta   struct {
+		Text string `json:"text"`
+	} `json:"delta"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Usage   *streamUsage `json:"usage"` // The last OpenAI chunk with stream_options, or Anthropic's message_delta
+	Message struct {
+		Usage *streamUsage `json:"usage"`
+	} `json:"message"` // Anthropic's message_start
+}
+
+// streamUsage is token usage reported in a stream, in either format
+type streamUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+}
+
+// StreamOptions asks an OpenAI-compatible API to end a stream with a chunk
+// carrying the request's token usage
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// streamUsageEnabled reports whether streamed requests ask for usage, which
+// stream_usage can turn off for backends that reject stream_options
+func streamUsageEnabled() bool {
+	return !viper.IsSet("stream_usage") || viper.GetBool("stream_usage")
+}
+
+// collect adds the counts a chunk reports. Anthropic sends the prompt count
+// when the message starts and a running completion count as it goes, so the
+// latest non-zero count of each kind wins.
+func (u *TokenUsage) collect(s *streamUsage) {
+	if s == nil {
+		return
+	}
+	if n := s.PromptTokens + s.InputTokens; n > 0 {
+		u.PromptTokens = n
+	}
+	if n := s.CompletionTokens + s.OutputTokens; n > 0 {
+		u.CompletionTokens = n
+	}
+}
+
+// estimateUsage guesses a request's usage at 4 characters per token, for
+// backends that don't report it in streams
+func estimateUsage(reqBody ChatRequest, reply string) TokenUsage {
+	prompt := 0
+	for _, m := range reqBody.Messages {
+		prompt += len(m.Content)
+	}
+	return TokenUsage{PromptTokens: prompt / 4, CompletionTokens: len(reply) / 4}
+}
+
+// text returns the reply text carried by a chunk in either format
+func (c ChatStreamChunk) text() string {
+	if len(c.Choices) > 0 {
+		return c.Choices[0].Delta.Content
+	}
+	if c.Type == "content_block_delta" {
+		return c.Delta.Text
+	}
+	return ""
+}
+
+type StreamChoice struct {
+	Delta Message `json:"delta"`
+}
+
+// sseReader reads server-sent events line by line. Lines are buffered until
+// their newline arrives, so an event split across network reads comes out
+// whole, and there's no limit on how long a line can be.
+type sseReader struct {
+	r *bufio.Reader
+}
+
+func newSSEReader(body io.Reader) *sseReader {
+	return &sseReader{r: bufio.NewReader(body)}
+}
+
+// Next returns the data of the next event, joining multi-line data fields.
+// Comment lines (keep-alives starting with ":") and events without data are
+// skipped. Returns io.EOF once the stream ends.
+func (s *sseReader) Next() (string, error) {
+	var data []string
+	for {
+		line, err := s.r.ReadString('\n')
+		if line != "" {
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case line == "" && len(data) > 0:
+				// A blank line ends the event
+				return strings.Join(data, "\n"), nil
+			case strings.HasPrefix(line, "data:"):
+				data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			}
+			// Anything else is a comment (":"), another field, or a blank
+			// line between events
+		}
+		if err != nil {
+			// A final event without the trailing blank line
+			if err == io.EOF && len(data) > 0 {
+				return strings.Join(data, "\n"), nil
+			}
+			return "", err
+		}
+	}
+}
+
+// readChatStream reads an SSE chat completion stream, calling onPartial with
+// the accumulated text after every chunk, and returns the full reply and the
+// usage the stream reported, or nil if it didn't
+func readChatStream(body io.Reader, onPartial func(string)) (string, *TokenUsage, error) {
+	events := newSSEReader(body)
+
+	var reply strings.Builder
+	var usage TokenUsage
+	for {
+		data, err := events.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk ChatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", nil, err
+		}
+		if chunk.Type == "message_stop" {
+			break
+		}
+		if chunk.Type == "error" && chunk.Error != nil {
+			return "", nil, fmt.Errorf("stream error: %s", chunk.Error.Message)
+		}
+		usage.collect(chunk.Usage)
+		usage.collect(chunk.Message.Usage)
+		text := chunk.text()
+		if text == "" {
+			continue
+		}
+
+		reply.WriteString(text)
+		if onPartial != nil {
+			onPartial(reply.String())
+		}
+	}
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		return reply.String(), nil, nil
+	}
+	return reply.String(), &usage, nil
+}
+
+// streamPreview renders a partial reply for an interim edit. Partial markdown
+// (an unclosed code fence, a dangling **) can't be converted to valid HTML, so
+// previews are always plain text and only the final edit is formatted.
+func streamPreview(text string) string {
+	maxLen := maxMessageLen() - 2 // Room for the " …"
+	text = text[:textPrefix(text, maxLen)]
+	return text + " …"
+}
+
+// streamReply sends msg with streaming enabled, live-editing the placeholder
+// message as the reply comes in; the caller replaces it with the final
+// formatted answer. stopped reports that /stop cancelled the reply and the
+// text received so far was left in the placeholder.
+func streamReply(ctx context.Context, chat *telebot.Chat, placeholder *telebot.Message, msg string, opts chatOptions) (response string, stopped bool, err error) {
+	if placeholder == nil {
+		response, err = sendChat(ctx, chat.ID, msg, opts)
+		return response, false, err
+	}
+
+	var lastEdit time.Time
+	var lastPreview, latest string
+	finalOnly := viper.GetBool("stream_final_only")
+	interval := streamEditInterval()
+	opts.OnPartial = func(partial string) {
+		latest = partial
+		// With stream_final_only the API streams but the chat only sees the
+		// finished answer
+		if finalOnly || time.Since(lastEdit) < interval {
+			return
+		}
+		preview := streamPreview(partial)
+		if preview == lastPreview {
+			return
+		}
+		if _, err := bot.Edit(placeholder, preview); err != nil {
+			requestLogger(ctx).Debug("stream edit failed", slog.Any("error", err))
+		}
+		lastEdit = time.Now()
+		lastPreview = preview
+	}
+	response, err = sendChat(ctx, chat.ID, msg, opts)
+	if errors.Is(err, context.Canceled) && latest != "" {
+		stopStreamReply(ctx, chat.ID, placeholder, msg, latest, opts)
+		return "", true, err
+	}
+	return response, false, err
+}
+
+// stopStreamReply leaves the text streamed before /stop in the placeholder,
+// marked as stopped. With stream_keep_partial it's also added to the
+// conversation, so it can be replied to, continued or undone; the history
+// gets all of it, without the marker.
+func stopStreamReply(ctx context.Context, chatID int64, placeholder *telebot.Message, msg, partial string, opts chatOptions) {
+	const marker = "\n\n[stopped]"
+	shown := partial[:textPrefix(partial, maxMessageLen()-textLen(marker))] + marker
+	if _, err := bot.Edit(placeholder, shown); err != nil {
+		requestLogger(ctx).Warn("failed to mark stopped stream", slog.Any("error", err))
+	}
+
+	state := cachedState(chatID)
+	if !viper.GetBool("stream_keep_partial") || state == nil || state.OneShot || opts.Stateless {
+		return
+	}
+	stateMu.Lock()
+	addExchange(state, msg, partial, opts)
+	saveUserState(chatID, state)
+	stateMu.Unlock()
+	rememberReply(chatID, []*telebot.Message{placeholder})
+}
+
+// finishReply replaces the placeholder, or the plain-text preview of a
+// streamed reply, with the fully formatted answer, falling back to a normal
+// send when it doesn't fit in one message. Returns the messages holding the
+// answer.
+func finishReply(ctx context.Context, to telebot.Recipient, placeholder *telebot.Message, response string) []*telebot.Message {
+	log := requestLogger(ctx)
+	if textLen(response) <= maxMessageLen() {
+		_, err := bot.Edit(placeholder, convertMarkdownToHTML(response), telebot.ModeHTML)
+		if err == nil {
+			return []*telebot.Message{placeholder}
+		}
+		log.Warn("formatted stream edit failed, trying plain", slog.Any("error", err))
+		_, err = bot.Edit(placeholder, response)
+		if err == nil || err == telebot.ErrMessageNotModified || err == telebot.ErrSameMessageContent {
+			return []*telebot.Message{placeholder}
+		}
+		log.Error("plain stream edit failed", slog.Any("error", err))
+	}
+
+	bot.Delete(placeholder)
+	return sendResponse(ctx, to, response)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// A stream read one byte at a time must come out the same as one read whole:
+// events are only parsed once their blank line has arrived
+func TestReadChatStreamOneByteAtATime(t *testing.T) {
+	body := "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+		": keep-alive\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\", wörld 👋\"}}]}\n\n" +
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":5}}\n\n" +
+		"data: [DONE]\n\n"
+
+	var partials []string
+	reply, usage, err := readChatStream(iotest.OneByteReader(strings.NewReader(body)), func(s string) {
+		partials = append(partials, s)
+	})
+	if err != nil {
+		t.Fatalf("readChatStream: %v", err)
+	}
+	if want := "Hello, wörld 👋"; reply != want {
+		t.Errorf("reply = %q, want %q", reply, want)
+	}
+	if want := []string{"Hello", "Hello, wörld 👋"}; !slices.Equal(partials, want) {
+		t.Errorf("partials = %q, want %q", partials, want)
+	}
+	if usage == nil {
+		t.Fatal("usage = nil, want the counts from the last chunk")
+	}
+	if usage.PromptTokens != 12 || usage.CompletionTokens != 5 {
+		t.Errorf("usage = %+v, want 12 prompt and 5 completion tokens", *usage)
+	}
+}
+
+// Streams served by a real HTTP server, each write flushed separately so the
+// client sees the same network reads a backend would cause
+func TestReadChatStreamFromServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{
+			name: "done ends the stream",
+			writes: []string{
+				"data: {\"choices\":[{\"delta\":{\"content\":\"one\"}}]}\n\n",
+				"data: [DONE]\n\n",
+				"data: {\"choices\":[{\"delta\":{\"content\":\" ignored\"}}]}\n\n",
+			},
+			want: "one",
+		},
+		{
+			name: "keep-alive comments are skipped",
+			writes: []string{
+				": keep-alive\n\n",
+				"data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\n",
+				":\n\n",
+				": OPENROUTER PROCESSING\n\n",
+				"data: {\"choices\":[{\"delta\":{\"content\":\"b\"}}]}\n\n",
+				"data: [DONE]\n\n",
+			},
+			want: "ab",
+		},
+		{
+			name: "role-only first chunk",
+			writes: []string{
+				"data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n",
+				"data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n",
+				"data: [DONE]\n\n",
+			},
+			want: "hi",
+		},
+		{
+			name: "event split across writes",
+			writes: []string{
+				"data: {\"choices\":[{\"delta\":{\"con",
+				"tent\":\"split\"}}]}\n",
+				"\n",
+				"data: [DONE]\n\n",
+			},
+			want: "split",
+		},
+		{
+			name: "multi-line data",
+			writes: []string{
+				"data: {\"choices\":[{\"delta\":\n",
+				"data: {\"content\":\"joined\"}}]}\n\n",
+				"data: [DONE]\n\n",
+			},
+			want: "joined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for _, part := range tt.writes {
+					w.Write([]byte(part))
+					w.(http.Flusher).Flush()
+				}
+			}))
+			defer server.Close()
+
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatalf("GET: %v", err)
+			}
+			defer resp.Body.Close()
+			reply, _, err := readChatStream(resp.Body, nil)
+			if err != nil {
+				t.Fatalf("readChatStream: %v", err)
+			}
+			if reply != tt.want {
+				t.Errorf("reply = %q, want %q", reply, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/telebot.v3"
+)
+
+const summaryPrompt = "Summarize the following conversation concisely. Keep key facts, decisions, " +
+	"user preferences and open questions so the conversation can be continued later. " +
+	"Reply with the summary only."
+
+// summarizeHistory asks the chat's model to condense its history (and any
+// summary it already carries) into a short summary
+func summarizeHistory(ctx context.Context, state *UserState) (string, error) {
+	var transcript strings.Builder
+	if state.Summary != "" {
+		transcript.WriteString("Summary of earlier conversation:\n" + state.Summary + "\n\n")
+	}
+	for _, m := range state.History {
+		transcript.WriteString(m.Role + ": " + m.Content + "\n\n")
+	}
+
+	summary, err := complete(ctx, effectiveModel(state), []ChatMessage{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: transcript.String()},
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(summary), nil
+}
+
+// archiveConversation saves the full conversation under data/store/archive
+func archiveConversation(chatID int64, state *UserState) (string, error) {
+	dir := "./data/store/archive"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	data, err := marshalConversation(state)
+	if err == nil {
+		data, err = sealData(data)
+	}
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("user_%d_%d.json", chatID, time.Now().Unix()))
+	return path, writeFileAtomic(path, data, 0644)
+}
+
+// newWithSummary starts a fresh conversation seeded with a summary of the
+// current one. The old conversation is archived first. /stop cancels it.
+func newWithSummary(c telebot.Context) error {
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+
+	if len(state.History) == 0 {
+		return c.Send("Nothing to summarize yet. Use /new for a plain fresh start.")
+	}
+
+	c.Send("Summarizing conversation...")
+	bot.Notify(c.Chat(), telebot.Typing)
+
+	ctx, done := beginRequest(chatID)
+	summary, err := summarizeHistory(ctx, state)
+	done()
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return c.Send("Summary cancelled. Your conversation was left unchanged.")
+		}
+		return c.Send("Failed to summarize conversation: " + err.Error())
+	}
+	if summary == "" {
+		return c.Send("The model returned an empty summary. Your conversation was left unchanged.")
+	}
+
+	// Private conversations are never written to disk, so aren't archived
+	if !state.Private {
+		path, err := archiveConversation(chatID, state)
+		if err != nil {
+			logger.Error("failed to archive conversation", slog.Int64("chat_id", chatID), slog.Any("error", err))
+			return c.Send("Failed to archive the old conversation, so it was left unchanged: " + err.Error())
+		}
+		logger.Info("conversation archived", slog.Int64("chat_id", chatID), slog.String("path", path))
+	}
+
+	// Same fresh start as /new, but seeded with the summary
+	os.Remove(getStateFilePath(chatID))
+	forgetUnsaved(chatID)
+	forgetPrivate(chatID)
+	uncacheState(chatID)
+
+	fresh := loadUserState(chatID)
+	fresh.Summary = summary
+	fresh.LifetimeUsage = state.LifetimeUsage
+	fresh.Private = state.Private
+	fresh.Conversations = state.Conversations
+	saveUserState(chatID, fresh)
+	cacheState(chatID, fresh)
+
+	return c.Send("New conversation started. The old one was archived and its summary carried over:\n\n" + summary)
+}
+
+// handleSummarize implements /summarize: the history is condensed into the
+// chat's summary, which is sent as a memory note after the system prompt.
+// Earlier summaries are folded into the new one. /stop cancels it.
+func handleSummarize(c telebot.Context) error {
+	chatID := c.Chat().ID
+	state := loadUserState(chatID)
+	cacheState(chatID, state)
+
+	if len(state.History) == 0 {
+		return c.Send("Nothing to summarize yet.")
+	}
+
+	c.Send("Summarizing conversation...")
+	bot.Notify(c.Chat(), telebot.Typing)
+
+	ctx, done := beginRequest(chatID)
+	summary, err := summarizeHistory(ctx, state)
+	done()
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return c.Send("Summary cancelled. Your conversation was left unchanged.")
+		}
+		return c.Send("Failed to summarize conversation: " + err.Error())
+	}
+	if summary == "" {
+		return c.Send("The model returned an empty summary. Your conversation was left unchanged.")
+	}
+
+	messages := len(state.History)
+	state.Summary = summary
+	state.History = nil
+	state.ReplyIndex = nil
+	saveUserState(chatID, state)
+
+	return c.Send(fmt.Sprintf("Condensed %d messages into a memory note:\n\n%s", messages, summary))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Longest title kept, in characters, in case the model ignores the word limit
+const maxTitleLen = 60
+
+const titlePrompt = "Write a title of 3 to 5 words for a conversation that starts with the user's message below. Reply with the title only, without quotes or a trailing period."
+
+// maybeGenerateTitle starts naming the chat's conversation after its first
+// exchange when auto_titles is on. It runs in the background so the answer
+// isn't held up, and isn't retried if it fails.
+func maybeGenerateTitle(chatID int64, state *UserState, message string) {
+	if !viper.GetBool("auto_titles") || state.Title != "" || len(state.History) != 2 {
+		return
+	}
+	go generateTitle(chatID, message)
+}
+
+// generateTitle asks the utility model for a title and stores it, unless
+// the conversation was replaced in the meantime. The request runs unlocked;
+// the title is applied under stateMu, like the worker's own updates.
+func generateTitle(chatID int64, message string) {
+	title, err := complete(context.Background(), utilityModel(), []ChatMessage{
+		{Role: "system", Content: titlePrompt},
+		{Role: "user", Content: truncateText(message, historyShownChars)},
+	})
+	if err != nil {
+		logger.Warn("failed to generate conversation title", slog.Int64("chat_id", chatID), slog.Any("error", err))
+		return
+	}
+	title = cleanTitle(title)
+	if title == "" {
+		return
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	state := cachedState(chatID)
+	if state == nil || state.Title != "" || len(state.History) == 0 || state.History[0].Content != message {
+		return
+	}
+	state.Title = title
+	saveUserState(chatID, state)
+	logger.Debug("conversation titled", slog.Int64("chat_id", chatID), slog.String("title", title))
+}
+
+// cleanTitle strips quotes, markdown and trailing punctuation models tend to
+// add, keeping the first line
+func cleanTitle(title string) string {
+	title, _, _ = strings.Cut(strings.TrimSpace(title), "\n")
+	title = strings.Trim(title, " \"'`*#.")
+	return truncateText(title, maxTitleLen)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo
+
+	"github.com/spf13/viper"
+)
+
+// Model/tool round trips allowed per message before giving up
+const maxToolRounds = 5
+
+// Tool is a local function the model can call when tools_enabled is set.
+// Handler gets the raw JSON arguments and returns the text sent back to the
+// model.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{} // JSON schema for the arguments
+	Handler     func(args json.RawMessage) (string, error)
+}
+
+// ToolSpec is the OpenAI "tools" request entry
+type ToolSpec struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ToolCall is a call requested by the model in an assistant message
+type ToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// Registered tools, in the order they're offered to the model
+var (
+	tools     = make(map[string]Tool)
+	toolOrder []string
+)
+
+// registerTool adds a tool to the registry
+func registerTool(t Tool) {
+	if _, ok := tools[t.Name]; !ok {
+		toolOrder = append(toolOrder, t.Name)
+	}
+	tools[t.Name] = t
+}
+
+func init() {
+	registerTool(Tool{
+		Name:        "get_current_time",
+		Description: "Get the current date and time, optionally in an IANA time zone like Europe/Berlin",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"timezone": map[string]interface{}{"type": "string", "description": "IANA time zone name, defaults to UTC"},
+			},
+		},
+		Handler: currentTimeTool,
+	})
+	registerTool(Tool{
+		Name:        "calculate",
+		Description: "Evaluate an arithmetic expression with + - * / % ^, parentheses and sqrt/abs, e.g. (2+3)^2/4",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"expression": map[string]interface{}{"type": "string", "description": "The expression to evaluate"},
+			},
+			"required": []string{"expression"},
+		},
+		Handler: calculateTool,
+	})
+}
+
+// toolSpecs returns the tools to offer the model, or nil if tools are off.
+// Tools are only supported with the OpenAI API format.
+func toolSpecs() []ToolSpec {
+	if !viper.GetBool("tools_enabled") || useAnthropic() {
+		return nil
+	}
+	specs := make([]ToolSpec, 0, len(toolOrder))
+	for _, name := range toolOrder {
+		t := tools[name]
+		specs = append(specs, ToolSpec{Type: "function", Function: ToolFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters}})
+	}
+	return specs
+}
+
+// runTool executes a tool call. Failures are reported to the model as the
+// result rather than aborting the request.
+func runTool(ctx context.Context, call ToolCall) string {
+	t, ok := tools[call.Function.Name]
+	if !ok {
+		return "error: unknown tool " + call.Function.Name
+	}
+	args := json.RawMessage(call.Function.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	result, err := t.Handler(args)
+	requestLogger(ctx).Debug("tool called",
+		slog.String("tool", t.Name),
+		slog.String("arguments", call.Function.Arguments),
+		slog.Any("error", err))
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return result
+}
+
+// chatWithTools sends a non-streaming request, running any tools the model
+// calls and sending their results back until it gives a final answer
+func chatWithTools(ctx context.Context, state *UserState, reqBody ChatRequest) (string, error) {
+	for round := 0; ; round++ {
+		resp, err := postChat(ctx, reqBody)
+		if err != nil {
+			return "", err
+		}
+		var response ChatResponse
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+		if err != nil {
+			requestLogger(ctx).Error("failed to parse response", slog.Any("error", err))
+			return "", err
+		}
+
+		if response.Usage != nil {
+			recordUsage(state, reqBody.Model, *response.Usage)
+			tokensConsumed.WithLabelValues("prompt").Add(float64(response.Usage.PromptTokens))
+			tokensConsumed.WithLabelValues("completion").Add(float64(response.Usage.CompletionTokens))
+		}
+
+		if len(response.Choices) == 0 {
+			return "", nil
+		}
+		reply := response.Choices[0].Message
+		if len(reply.ToolCalls) == 0 {
+			return reply.Content, nil
+		}
+		if round >= maxToolRounds {
+			return "", fmt.Errorf("model was still calling tools after %d rounds", maxToolRounds)
+		}
+
+		reqBody.Messages = append(reqBody.Messages, ChatMessage{Role: "assistant", Content: reply.Content, ToolCalls: reply.ToolCalls})
+		for _, call := range reply.ToolCalls {
+			reqBody.Messages = append(reqBody.Messages, ChatMessage{Role: "tool", Content: runTool(ctx, call), ToolCallID: call.ID})
+		}
+	}
+}
+
+func currentTimeTool(args json.RawMessage) (string, error) {
+	var params struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	loc := time.UTC
+	if params.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(params.Timezone); err != nil {
+			return "", fmt.Errorf("unknown time zone %q", params.Timezone)
+		}
+	}
+	return time.Now().In(loc).Format("Monday, 2 January 2006 15:04:05 MST"), nil
+}
+
+func calculateTool(args json.RawMessage) (string, error) {
+	var params struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	result, err := calculate(params.Expression)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(result, 'g', -1, 64), nil
+}
+
+// calculate evaluates an arithmetic expression with + - * / % ^ (power),
+// parentheses, unary minus and sqrt/abs
+func calculate(expr string) (float64, error) {
+	p := &calcParser{input: strings.ReplaceAll(expr, " ", "")}
+	v, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos+1)
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("result is not a finite number")
+	}
+	return v, nil
+}
+
+// calcParser is a recursive descent parser over the expression grammar:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/" | "%") unary }
+//	unary   = ("+" | "-") unary | power
+//	power   = primary [ "^" unary ]
+//	primary = number | "(" expr ")" | ("sqrt" | "abs") "(" expr ")"
+//
+// Every recursion passes through unary, which caps the nesting depth so a
+// model-supplied expression can't exhaust the stack.
+type calcParser struct {
+	input string
+	pos   int
+	depth int
+}
+
+// maxCalcDepth is how deeply parentheses, functions and signs may nest
+const maxCalcDepth = 100
+
+func (p *calcParser) peek() byte {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *calcParser) expr() (float64, error) {
+	x, err := p.term()
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op := p.peek()
+		p.pos++
+		var y float64
+		if y, err = p.term(); op == '+' {
+			x += y
+		} else {
+			x -= y
+		}
+	}
+	return x, err
+}
+
+func (p *calcParser) term() (float64, error) {
+	x, err := p.unary()
+	for err == nil && (p.peek() == '*' || p.peek() == '/' || p.peek() == '%') {
+		op := p.peek()
+		p.pos++
+		var y float64
+		if y, err = p.unary(); err != nil {
+			break
+		}
+		switch {
+		case op == '*':
+			x *= y
+		case y == 0:
+			err = fmt.Errorf("division by zero")
+		case op == '/':
+			x /= y
+		default:
+			x = math.Mod(x, y)
+		}
+	}
+	return x, err
+}
+
+func (p *calcParser) unary() (float64, error) {
+	if p.depth++; p.depth > maxCalcDepth {
+		return 0, fmt.Errorf("expression is nested too deeply")
+	}
+	defer func() { p.depth-- }()
+
+	switch p.peek() {
+	case '-':
+		p.pos++
+		x, err := p.unary()
+		return -x, err
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+	return p.power()
+}
+
+func (p *calcParser) power() (float64, error) {
+	x, err := p.primary()
+	if err != nil || p.peek() != '^' {
+		return x, err
+	}
+	p.pos++
+	y, err := p.unary()
+	return math.Pow(x, y), err
+}
+
+func (p *calcParser) primary() (float64, error) {
+	for name, fn := range map[string]func(float64) float64{"sqrt": math.Sqrt, "abs": math.Abs} {
+		if strings.HasPrefix(p.input[p.pos:], name+"(") {
+			p.pos += len(name)
+			x, err := p.primary()
+			return fn(x), err
+		}
+	}
+	if p.peek() == '(' {
+		p.pos++
+		x, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return x, nil
+	}
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] >= '0' && p.input[p.pos] <= '9' || p.input[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		if p.pos >= len(p.input) {
+			return 0, fmt.Errorf("unexpected end of expression")
+		}
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos+1)
+	}
+	return strconv.ParseFloat(p.input[start:p.pos], 64)
+}
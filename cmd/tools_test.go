@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"-(2 ^ 3) % 5", -3},
+		{"2 ^ 3 ^ 2", 512},
+		{"sqrt(16) + abs(-2)", 6},
+		{strings.Repeat("(", 50) + "1" + strings.Repeat(")", 50), 1},
+	}
+	for _, tt := range tests {
+		got, err := calculate(tt.expr)
+		if err != nil {
+			t.Errorf("calculate(%q): %v", tt.expr, err)
+		} else if got != tt.want {
+			t.Errorf("calculate(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+// Deeply nested input is rejected instead of recursing until the stack runs out
+func TestCalculateNestingLimit(t *testing.T) {
+	for _, expr := range []string{
+		strings.Repeat("(", 1_000_000) + "1" + strings.Repeat(")", 1_000_000),
+		strings.Repeat("-", 1_000_000) + "1",
+		strings.Repeat("sqrt(", 1_000_000) + "1" + strings.Repeat(")", 1_000_000),
+		strings.Repeat("2^", 1_000_000) + "1",
+	} {
+		if _, err := calculate(expr); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+			t.Errorf("calculate(%.10q...) error = %v, want the nesting limit", expr, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// TokenUsage is the OpenAI "usage" object, also used for per-user totals
+type TokenUsage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost,omitempty"` // Estimated cost, only tracked when model_prices is configured
+}
+
+// ModelPrice is an entry in the model_prices config list. Prices are per
+// million tokens.
+type ModelPrice struct {
+	Model      string  `mapstructure:"model"`
+	Prompt     float64 `mapstructure:"prompt"`
+	Completion float64 `mapstructure:"completion"`
+}
+
+// modelPrice looks up the configured price for a model
+func modelPrice(model string) (ModelPrice, bool) {
+	var prices []ModelPrice
+	if err := viper.UnmarshalKey("model_prices", &prices); err != nil {
+		logger.Warn("invalid model_prices in config")
+		return ModelPrice{}, false
+	}
+	for _, p := range prices {
+		if strings.EqualFold(p.Model, model) {
+			return p, true
+		}
+	}
+	return ModelPrice{}, false
+}
+
+// add accumulates another response's usage into the totals
+func (u *TokenUsage) add(other TokenUsage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Cost += other.Cost
+}
+
+// recordUsage adds a response's usage to the chat's session and lifetime
+// totals, pricing it if the model has a configured price
+func recordUsage(state *UserState, model string, usage TokenUsage) {
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	if price, ok := modelPrice(model); ok {
+		usage.Cost = (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+	}
+	state.SessionUsage.add(usage)
+	state.LifetimeUsage.add(usage)
+}
+
+func formatUsage(u TokenUsage) string {
+	line := fmt.Sprintf("%d tokens (prompt %d, completion %d)", u.TotalTokens, u.PromptTokens, u.CompletionTokens)
+	if u.Cost > 0 {
+		line += fmt.Sprintf(", ~$%.4f", u.Cost)
+	}
+	return line
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// Photos kept in a conversation unless max_images says otherwise
+const defaultMaxImages = 3
+
+// Prompt used when a photo is sent without a caption
+const defaultPhotoPrompt = "Describe this image."
+
+// messageImage is a photo's data, loaded for a request
+type messageImage struct {
+	MIME string
+	Data []byte
+}
+
+// dataURL encodes the image for the OpenAI image_url content part
+func (img messageImage) dataURL() string {
+	return "data:" + img.MIME + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// handlePhoto queues a photo's caption, or a request to describe it, with the
+// photo attached. The photo stays in the conversation so follow-up questions
+// can refer to it.
+func handlePhoto(c telebot.Context) error {
+	prompt := strings.TrimSpace(c.Message().Caption)
+	if prompt == "" {
+		prompt = defaultPhotoPrompt
+	}
+	return enqueueMessage(c, queuedMessage{
+		Text:      prompt,
+		MessageID: c.Message().ID,
+		Sender:    senderName(c.Sender().FirstName, c.Sender().Username),
+		Images:    []string{c.Message().Photo.FileID},
+	})
+}
+
+// downloadImage fetches a photo by its Telegram file ID
+func downloadImage(fileID string) (messageImage, error) {
+	reader, err := bot.File(&telebot.File{FileID: fileID})
+	if err != nil {
+		return messageImage{}, fmt.Errorf("failed to download photo: %w", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return messageImage{}, fmt.Errorf("failed to read photo: %w", err)
+	}
+	return messageImage{MIME: http.DetectContentType(data), Data: data}, nil
+}
+
+// loadImages fills in the data of the photos carried by messages. Photos that
+// can't be downloaded any more are left out, with a warning in the log.
+func loadImages(messages []ChatMessage) {
+	for i := range messages {
+		if len(messages[i].Images) == 0 {
+			continue
+		}
+		images := make([]messageImage, 0, len(messages[i].Images))
+		for _, fileID := range messages[i].Images {
+			img, err := downloadImage(fileID)
+			if err != nil {
+				logger.Warn("photo left out of request", slog.String("file_id", fileID), slog.Any("error", err))
+				continue
+			}
+			images = append(images, img)
+		}
+		messages[i].images = images
+	}
+}
+
+// maxImages is how many photos a conversation keeps (max_images)
+func maxImages() int {
+	if n := viper.GetInt("max_images"); n > 0 {
+		return n
+	}
+	return defaultMaxImages
+}
+
+// evictImages drops photos from the history beyond the newest max_images,
+// oldest first. The text of their messages is kept.
+func evictImages(state *UserState) {
+	kept := 0
+	for i := len(state.History) - 1; i >= 0; i-- {
+		m := &state.History[i]
+		if len(m.Images) == 0 {
+			continue
+		}
+		if room := maxImages() - kept; len(m.Images) > room {
+			m.Images = m.Images[len(m.Images)-max(room, 0):]
+		}
+		if len(m.Images) == 0 {
+			m.Images = nil
+		}
+		kept += len(m.Images)
+	}
+}
+
+// hasImages reports whether any message in a request carries photo data
+func hasImages(messages []ChatMessage) bool {
+	for _, m := range messages {
+		if len(m.images) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// visionRequest is a chat completion request whose messages may carry photos
+// as content parts. Its Messages field takes the place of ChatRequest's.
+type visionRequest struct {
+	ChatRequest
+	Messages []visionMessage `json:"messages"`
+}
+
+type visionMessage struct {
+	Role       string      `json:"role"`
+	Content    interface{} `json:"content"` // Text, or a list of parts for messages with photos
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+}
+
+type contentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *contentPartURL `json:"image_url,omitempty"`
+}
+
+type contentPartURL struct {
+	URL string `json:"url"`
+}
+
+// toVisionRequest converts a request with photos to the OpenAI content part
+// format
+func toVisionRequest(r ChatRequest) visionRequest {
+	out := visionRequest{ChatRequest: r, Messages: make([]visionMessage, 0, len(r.Messages))}
+	for _, m := range r.Messages {
+		vm := visionMessage{Role: m.Role, Content: m.Content, ToolCalls: m.ToolCalls, ToolCallID: m.ToolCallID}
+		if len(m.images) > 0 {
+			parts := make([]contentPart, 0, len(m.images)+1)
+			for _, img := range m.images {
+				parts = append(parts, contentPart{Type: "image_url", ImageURL: &contentPartURL{URL: img.dataURL()}})
+			}
+			vm.Content = append(parts, contentPart{Type: "text", Text: m.Content})
+		}
+		out.Messages = append(out.Messages, vm)
+	}
+	return out
+}
+
+// MarshalJSON sends messages with photos as Anthropic content blocks
+func (m anthropicMessage) MarshalJSON() ([]byte, error) {
+	type plain anthropicMessage
+	if len(m.Images) == 0 {
+		return json.Marshal(plain(m))
+	}
+	blocks := make([]interface{}, 0, len(m.Images)+1)
+	for _, img := range m.Images {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "image",
+			"source": map[string]string{
+				"type":       "base64",
+				"media_type": img.MIME,
+				"data":       base64.StdEncoding.EncodeToString(img.Data),
+			},
+		})
+	}
+	blocks = append(blocks, map[string]string{"type": "text", "text": m.Content})
+	return json.Marshal(struct {
+		Role    string        `json:"role"`
+		Content []interface{} `json:"content"`
+	}{m.Role, blocks})
+}
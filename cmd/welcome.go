@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/telebot.v3"
+)
+
+// defaultWelcomeMessage is used for /start unless welcome_message is set.
+// Besides the system prompt placeholders it can use {{model}} and
+// {{commands}}.
+const defaultWelcomeMessage = "Welcome! I'm your AI assistant.\n\nCurrent model: {{model}}\n\nCommands:\n{{commands}}"
+
+// startCommands is the command list shown by /start
+var startCommands = []struct {
+	command string // Command name without arguments, checked against disabled_commands
+	usage   string
+}{
+	{"model", "/model [name] - Switch model"},
+	{"models", "/models - List models"},
+	{"recommend", "/recommend <task> - Suggest a model"},
+	{"quota", "/quota - Show provider usage"},
+	{"set", "/set <name> <model> [temperature=0.7 ...] <prompt> - Save preset"},
+	{"preset", "/preset - List presets"},
+	{"preset", "/preset <name> or /<n> - Load preset"},
+	{"new", "/new - New conversation"},
+	{"undo", "/undo - Remove last exchange"},
+	{"forget", "/forget <n> - Remove message n (see /history show)"},
+	{"retry", "/retry <model> - Answer last message with another model"},
+	{"compare", "/compare <modelA> <modelB> <prompt> - Compare two models"},
+	{"effort", "/effort low|medium|high - Set reasoning effort"},
+	{"seed", "/seed <n>|off - Fixed sampling seed"},
+	{"maxtokens", "/maxtokens <n> - Limit answer length"},
+	{"history", "/history <n> - Set how many exchanges to remember"},
+	{"oneshot", "/oneshot on|off - Answer without memory"},
+	{"json", "/json on|off - Ask for answers as JSON"},
+	{"batch", "/batch on|off - Combine messages sent while busy"},
+	{"private", "/private on|off - Don't save this conversation to disk"},
+	{"new", "/new with-summary - New conversation, keep a summary"},
+	{"save", "/save <name> - Save this conversation"},
+	{"load", "/load <name> - Switch to a saved conversation"},
+	{"conversations", "/conversations - List saved conversations"},
+	{"stop", "/stop - Cancel the current request"},
+	{"usage", "/usage - Token usage"},
+	{"chain", "/chain - Run a prompt chain"},
+	{"export", "/export - Download conversation"},
+	{"clearfile", "/clearfile - Drop the attached file"},
+	{"role", "/role <name> - Use a shared system prompt"},
+	{"roles", "/roles - List shared system prompts"},
+	{"reset", "/reset - Reset system prompt"},
+	{"whoami", "/whoami - Show your user and chat ID"},
+	{"ping", "/ping - Check the API is reachable"},
+}
+
+// messageCommand returns the command a message runs, without the slash or
+// @botname suffix, or "" if it isn't a command
+func messageCommand(c telebot.Context) string {
+	msg := c.Message()
+	if msg == nil || !strings.HasPrefix(msg.Text, "/") {
+		return ""
+	}
+	command := strings.TrimPrefix(strings.Fields(msg.Text)[0], "/")
+	command, _, _ = strings.Cut(command, "@") // /cmd@botname in groups
+	return command
+}
+
+// commandDisabled reports whether a command (without the slash) is listed
+// in disabled_commands
+func commandDisabled(command string) bool {
+	for _, disabled := range viper.GetStringSlice("disabled_commands") {
+		if strings.EqualFold(strings.TrimPrefix(disabled, "/"), command) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandList renders the /start command list, leaving out disabled commands
+func commandList() string {
+	var lines []string
+	for _, cmd := range startCommands {
+		if !commandDisabled(cmd.command) {
+			lines = append(lines, cmd.usage)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// welcomeMessage renders the /start text: the welcome message translated for
+// the user's language, welcome_message, or the default
+func welcomeMessage(state *UserState, username, lang string) string {
+	vars := map[string]string{"model": effectiveModel(state), "commands": commandList()}
+	text := translation(lang, "welcome")
+	if text == "" {
+		text = viper.GetString("welcome_message")
+	}
+	if text == "" {
+		text = defaultWelcomeMessage
+	}
+	return expandPlaceholders(text, username, vars)
+}